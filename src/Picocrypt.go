@@ -2,7 +2,7 @@ package main
 
 /*
 
-Picocrypt v1.49
+Picocrypt v1.50
 Copyright (c) Evan Su
 Released under GPL-3.0-only
 https://github.com/Picocrypt/Picocrypt
@@ -13,38 +13,71 @@ https://github.com/Picocrypt/Picocrypt
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/zlib"
+	"context"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"crypto/hmac"
+	"crypto/mlkem"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"hash"
+	"html"
 	"image"
 	"image/color"
+	_ "image/jpeg"
+	"image/png"
 	"io"
+	"log"
 	"math"
 	"math/big"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	_ "embed"
+
 	"github.com/Picocrypt/dialog"
 	"github.com/Picocrypt/giu"
 	"github.com/Picocrypt/imgui-go"
 	"github.com/Picocrypt/infectious"
 	"github.com/Picocrypt/serpent"
 	"github.com/Picocrypt/zxcvbn-go"
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/crypto/chacha20"
 	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/sha3"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/webdav"
 )
 
 // Constants
@@ -61,7 +94,7 @@ var TRANSPARENT = color.RGBA{0x00, 0x00, 0x00, 0x00}
 
 // Generic variables
 var window *giu.MasterWindow
-var version = "v1.49"
+var version = "v1.50"
 var dpi float32
 var mode string
 var working bool
@@ -71,6 +104,13 @@ var scanning bool
 var modalId int
 var showPassgen bool
 var showKeyfile bool
+var showRecipients bool
+var showHidden bool
+var showSigning bool
+var showBackup bool
+var showProfiles bool
+var showQueue bool
+var showResults bool
 var showOverwrite bool
 var showProgress bool
 
@@ -103,6 +143,59 @@ var keyfile bool
 var keyfiles []string
 var keyfileOrdered bool
 var keyfileLabel = "None selected"
+var shares bool // Generate the keyfiles as shares of a random key instead
+var sharesNeeded int32 = 2
+var sharesTotal int32 = 3
+var keyfileWords string // A generated keyfile as words from the word list
+var backupFile string   // What to make a paper backup of
+var keyfileSetName string
+
+// Job queue variables
+var jobs []*job
+var jobsLock sync.Mutex // Held while changing 'jobs' or their states
+var jobsRunning bool
+var jobId int
+
+// Batch variables
+var batchKeys map[string][]byte // Argon2 outputs by salt and password hash, while working recursively
+var batchSalt []byte            // The Argon2 salt shared by volumes encrypted recursively
+var batchDenySalt []byte        // The same, but for the outer layer of deniable volumes
+var batchResults []batchResult  // What happened to each file of the last recursive batch
+
+// Hashes of keyfiles of at least 64 MiB are kept for the rest of the session
+var keyfileCache = make(map[string][]byte)
+
+const keyfileCacheSize = 64 * MiB
+
+// Incremental backup variables
+var deletedFiles []string // Paths removed since the last backup, listed in the volume's zip
+const deletedList = "picocrypt-deleted"
+
+// Mount variables
+var sealKey []byte              // Set while mounting, so that work() decrypts into a sealedFile
+var openedPayload *volumeReader // Set by work() instead when mounting a volume it can read in place
+
+// The BIP39 English word list, for writing down generated keyfiles
+//
+//go:embed wordlist.txt
+var wordlistFile string
+var wordlist = strings.Fields(wordlistFile)
+
+// Recipient variables
+var recipient bool          // The volume being decrypted needs an identity
+var recipients []*pem.Block // Recipients' public keys if encrypting, identities if decrypting
+var recipientLabel = "None selected"
+
+// Hidden volume variables
+var hiddenVolume bool
+var hiddenFile string
+var hiddenPassword string
+var hiddenCPassword string
+
+// Signing variables
+var sign bool
+var signingKey ed25519.PrivateKey
+var signingKeyLabel = "None selected"
 
 // Comments variables
 var comments string
@@ -112,12 +205,20 @@ var commentsDisabled bool
 // Advanced options
 var paranoid bool
 var reedsolo bool
+var backupHeader bool
 var deniability bool
 var recursively bool
+var outputRoot string // Where recursive outputs go, mirroring the input folders, instead of next to the inputs
+var randomNames bool  // Name recursive outputs randomly, with an encrypted index of the real names
+var profileName string
 var split bool
 var splitSize string
 var splitUnits = []string{"KiB", "MiB", "GiB", "TiB", "Total"}
 var splitSelected int32 = 1
+var padding bool
+var padSize string
+var padUnits = []string{"KiB", "MiB", "GiB", "2^n", "%"}
+var padSelected int32 = 1
 var recombine bool
 var compress bool
 var delete bool
@@ -130,6 +231,7 @@ var kept bool
 var startLabel = "Start"
 var mainStatus = "Ready"
 var mainStatusColor = WHITE
+var mainOutcome outcome // How the last operation ended, set along with 'mainStatus'
 var popupStatus string
 var requiredFreeSpace int64
 
@@ -175,6 +277,27 @@ func (p *compressorProgress) Read(data []byte) (int, error) {
 	return read, err
 }
 
+// The input of work(), which decrypts the outer layer of a deniable volume as it's read
+type inputReader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// The output of work(), which encrypts the outer layer of a deniable volume as it's written
+type outputWriter interface {
+	io.WriteSeeker
+	io.ReaderAt
+	io.Closer
+	Name() string
+}
+
+// An output of work() on a server, where closing it without finishing it leaves nothing behind
+type remoteWriter interface {
+	outputWriter
+	finish() error
+	failed() error // Why writing to the server failed, if it did
+}
+
 type encryptedZipWriter struct {
 	_w      io.Writer
 	_cipher *chacha20.Cipher
@@ -204,44 +327,147 @@ func (ezr *encryptedZipReader) Read(data []byte) (n int, err error) {
 	return n, err
 }
 
-func onClickStartButton() {
+// A decrypted volume kept on disk encrypted with XChaCha20 under a key that only stays in memory,
+// so it can be read back in any order without the plaintext ever being written
+type sealedFile struct {
+	file *os.File
+	key  []byte
+	pos  int64
+}
+
+// Encrypt or decrypt 'data' at 'off', with a nonce for each GiB so the counter never overflows
+func (s *sealedFile) xor(data []byte, off int64) {
+	for len(data) > 0 {
+		nonce := make([]byte, 24)
+		binary.LittleEndian.PutUint64(nonce, uint64(off/GiB))
+		chacha, err := chacha20.NewUnauthenticatedCipher(s.key, nonce)
+		if err != nil {
+			panic(err)
+		}
+		chacha.SetCounter(uint32(off % GiB / 64))
+		skip := make([]byte, off%64)
+		chacha.XORKeyStream(skip, skip)
+		n := min(int64(len(data)), GiB-off%GiB)
+		chacha.XORKeyStream(data[:n], data[:n])
+		data, off = data[n:], off+n
+	}
+}
+
+func (s *sealedFile) Write(data []byte) (int, error) {
+	dst := make([]byte, len(data))
+	copy(dst, data)
+	s.xor(dst, s.pos)
+	n, err := s.file.Write(dst)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *sealedFile) ReadAt(data []byte, off int64) (int, error) {
+	n, err := s.file.ReadAt(data, off)
+	s.xor(data[:n], off)
+	return n, err
+}
+
+func (s *sealedFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := s.file.Seek(offset, whence)
+	if err == nil {
+		s.pos = pos
+	}
+	return pos, err
+}
+
+func (s *sealedFile) Name() string {
+	return s.file.Name()
+}
+
+func (s *sealedFile) Close() error {
+	return s.file.Close()
+}
+
+// Make sure the options are complete and valid, showing what's wrong if not
+func checkOptions() bool {
 	// Start button should be disabled if these conditions are true; don't do anything if so
-	if (len(keyfiles) == 0 && password == "") || (mode == "encrypt" && password != cpassword) {
-		return
+	if (len(keyfiles) == 0 && password == "" && len(recipients) == 0 && !shares) || (mode == "encrypt" && password != cpassword) {
+		return false
 	}
 
 	if keyfile && keyfiles == nil {
 		mainStatus = "Please select your keyfiles"
 		mainStatusColor = RED
 		giu.Update()
-		return
+		return false
+	}
+	if recipient && recipients == nil {
+		mainStatus = "Please select your identity"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && len(recipients) > 0 && password != "" && !deniability {
+		mainStatus = "Recipients don't use a password"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && deniability && hiddenVolume && shares {
+		mainStatus = "Hidden volumes can't use shares"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && deniability && len(keyfiles) == 0 && password == "" && !shares {
+		mainStatus = "Deniability needs a password or keyfiles"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && isRemote(outputFile) && (deniability || shares) {
+		mainStatus = "Deniability and shares need a local output"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && isRemote(outputFile) && split {
+		mainStatus = "Splitting needs a local output"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && sign && signingKey == nil {
+		mainStatus = "Please select your signing key"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	if mode == "encrypt" && deniability && hiddenVolume && hiddenPassword == password {
+		mainStatus = "Hidden password must be different"
+		mainStatusColor = RED
+		giu.Update()
+		return false
 	}
 	tmp, err := strconv.Atoi(splitSize)
 	if split && (splitSize == "" || err != nil || tmp <= 0) {
 		mainStatus = "Invalid chunk size"
 		mainStatusColor = RED
 		giu.Update()
-		return
+		return false
 	}
+	if padding && padSelected != 3 && padAmount() == 0 {
+		mainStatus = "Invalid padding size"
+		mainStatusColor = RED
+		giu.Update()
+		return false
+	}
+	return true
+}
 
-	// Check if output file already exists
-	_, err = os.Stat(outputFile)
-
-	// Check if any split chunks already exist
-	if split {
-		names, err2 := filepath.Glob(outputFile + ".*")
-		if err2 != nil {
-			panic(err2)
-		}
-		if len(names) > 0 {
-			err = nil
-		} else {
-			err = os.ErrNotExist
-		}
+func onClickStartButton() {
+	if !checkOptions() {
+		return
 	}
 
 	// If files already exist, show the overwrite modal
-	if err == nil && !recursively {
+	if outputExists() && !recursively {
 		showOverwrite = true
 		modalId++
 		giu.Update()
@@ -265,60 +491,96 @@ func onClickStartButton() {
 			oldKeyfiles := keyfiles
 			oldKeyfileOrdered := keyfileOrdered
 			oldKeyfileLabel := keyfileLabel
+			oldShares := shares
+			oldSharesNeeded := sharesNeeded
+			oldSharesTotal := sharesTotal
+			oldRecipients := recipients
+			oldRecipientLabel := recipientLabel
 			oldComments := comments
 			oldParanoid := paranoid
 			oldReedsolo := reedsolo
+			oldBackupHeader := backupHeader
 			oldDeniability := deniability
 			oldSplit := split
 			oldSplitSize := splitSize
 			oldSplitSelected := splitSelected
+			oldPadding := padding
+			oldPadSize := padSize
+			oldPadSelected := padSelected
+			oldSign := sign
 			oldDelete := delete
 			files := allFiles
 			go func() {
-				for _, file := range files {
-					// Simulate dropping the file
-					onDrop([]string{file})
-
-					// Restore variables and options
+				// Restore variables and options after each file is dropped
+				done := workBatch(files, func() {
 					password = oldPassword
 					cpassword = oldPassword
-					keyfile = oldKeyfile
-					keyfiles = oldKeyfiles
-					keyfileOrdered = oldKeyfileOrdered
-					keyfileLabel = oldKeyfileLabel
+					if mode != "decrypt" || keyfile || deniability {
+						keyfiles = oldKeyfiles
+						keyfileLabel = oldKeyfileLabel
+					}
+					if mode != "decrypt" { // Volumes have these in their headers
+						keyfile = oldKeyfile
+						keyfileOrdered = oldKeyfileOrdered
+						shares = oldShares
+						sharesNeeded = oldSharesNeeded
+						sharesTotal = oldSharesTotal
+						recipients = oldRecipients
+						recipientLabel = oldRecipientLabel
+						deniability = oldDeniability
+					} else if deniability {
+						keyfileOrdered = oldKeyfileOrdered
+					}
 					comments = oldComments
 					paranoid = oldParanoid
 					reedsolo = oldReedsolo
-					if mode != "decrypt" {
-						deniability = oldDeniability
-					}
+					backupHeader = oldBackupHeader
 					split = oldSplit
 					splitSize = oldSplitSize
 					splitSelected = oldSplitSelected
+					padding = oldPadding
+					padSize = oldPadSize
+					padSelected = oldPadSelected
+					sign = oldSign
 					delete = oldDelete
-
-					work()
-					if !working {
-						resetUI()
-						cancel(nil, nil)
-						showProgress = false
-						giu.Update()
-						return
-					}
+				})
+				if !done {
+					resetUI()
+					cancel(nil, nil)
+					showProgress = false
+					giu.Update()
+					return
 				}
 				working = false
 				showProgress = false
+				resetUI()
+				mainStatus, mainStatusColor = batchSummary()
+				showResults = true
+				modalId++
 				giu.Update()
 			}()
 		}
 	}
 }
 
+// Check if the output file or any of its split chunks already exist
+func outputExists() bool {
+	if split {
+		names, err := filepath.Glob(outputFile + ".*")
+		if err != nil {
+			panic(err)
+		}
+		return len(names) > 0
+	}
+	_, err := os.Stat(outputFile)
+	return err == nil
+}
+
 // The main user interface
 func draw() {
 	giu.SingleWindow().Flags(524351).Layout(
 		giu.Custom(func() {
-			if giu.IsKeyReleased(giu.KeyEnter) {
+			if giu.IsKeyReleased(giu.KeyEnter) && !showHidden {
 				onClickStartButton()
 				return
 			}
@@ -358,7 +620,29 @@ func draw() {
 				giu.PopupModal("Manage keyfiles:##"+strconv.Itoa(modalId)).Flags(70).Layout(
 					giu.Label("Drag and drop your keyfiles here"),
 					giu.Custom(func() {
-						if mode != "decrypt" {
+						if mode == "encrypt" {
+							giu.Checkbox("Split into shares", &shares).OnChange(func() {
+								keyfiles = nil
+								keyfileOrdered = false
+								updateSharesLabel()
+							}).Build()
+							giu.Tooltip("Generate keyfiles of which only some are needed").Build()
+						}
+						if mode == "encrypt" && shares {
+							giu.Row(
+								giu.Label("Shares:"),
+								giu.SliderInt(&sharesTotal, 2, 16).Size(giu.Auto).OnChange(func() {
+									sharesNeeded = min(sharesNeeded, sharesTotal)
+									updateSharesLabel()
+								}),
+							).Build()
+							giu.Row(
+								giu.Label("Needed:"),
+								giu.SliderInt(&sharesNeeded, 2, sharesTotal).Size(giu.Auto).OnChange(func() {
+									updateSharesLabel()
+								}),
+							).Build()
+						} else if mode != "decrypt" || deniability {
 							giu.Checkbox("Require correct order", &keyfileOrdered).Build()
 							giu.Tooltip("Ordering of keyfiles will matter").Build()
 						} else if keyfileOrdered {
@@ -373,9 +657,62 @@ func draw() {
 							giu.Label(filepath.Base(i)).Build()
 						}
 					}),
+					giu.Custom(func() {
+						if mode == "encrypt" && shares {
+							return
+						}
+						giu.Separator().Build()
+						giu.Label("Saved sets:").Build()
+						sets := keyfileSets()
+						if len(sets) == 0 {
+							giu.Label("None").Build()
+						}
+						for _, i := range sets {
+							name := i.Name
+							giu.Row(
+								giu.Button("Load##"+name).OnClick(func() {
+									paths, ordered, err := loadKeyfileSet(name)
+									if err != nil {
+										mainStatus = "Unable to load keyfiles (" + err.Error() + ")"
+										mainStatusColor = RED
+										giu.Update()
+										return
+									}
+									keyfiles = nil
+									onDrop(paths)
+									if mode != "decrypt" || deniability {
+										keyfileOrdered = ordered
+									}
+								}),
+								giu.Button("Remove##"+name).OnClick(func() {
+									if err := removeKeyfileSet(name); err != nil {
+										mainStatus = "Failed to update keyfile sets"
+										mainStatusColor = RED
+									}
+									giu.Update()
+								}),
+								giu.Label(name),
+							).Build()
+						}
+						giu.Row(
+							giu.InputText(&keyfileSetName).Hint("Name of this set").Size(204),
+							giu.Style().SetDisabled(len(keyfiles) == 0 || strings.TrimSpace(keyfileSetName) == "").To(
+								giu.Button("Save").Size(100, 0).OnClick(func() {
+									if err := saveKeyfileSet(keyfileSetName, keyfiles, keyfileOrdered); err != nil {
+										mainStatus = "Failed to save keyfile set"
+										mainStatusColor = RED
+									}
+									keyfileSetName = ""
+									giu.Update()
+								}),
+								giu.Tooltip("Remember these keyfiles and their ordering"),
+							),
+						).Build()
+					}),
 					giu.Row(
 						giu.Button("Clear").Size(100, 0).OnClick(func() {
 							keyfiles = nil
+							shares = false
 							if keyfile {
 								keyfileLabel = "Keyfiles required"
 							} else {
@@ -386,6 +723,21 @@ func draw() {
 						}),
 						giu.Tooltip("Remove all keyfiles"),
 
+						giu.Button("Backup").Size(100, 0).OnClick(func() {
+							keyfileWords = ""
+							backupFile = ""
+							if len(keyfiles) == 1 {
+								backupFile = keyfiles[0]
+								keyfileWords, _ = exportWords(keyfiles[0])
+							}
+							giu.CloseCurrentPopup()
+							showKeyfile = false
+							showBackup = true
+							modalId++
+							giu.Update()
+						}),
+						giu.Tooltip("Back up a keyfile on paper or as words, or restore one"),
+
 						giu.Button("Done").Size(100, 0).OnClick(func() {
 							giu.CloseCurrentPopup()
 							showKeyfile = false
@@ -396,99 +748,510 @@ func draw() {
 				giu.Update()
 			}
 
-			if showOverwrite {
-				giu.PopupModal("Warning:##"+strconv.Itoa(modalId)).Flags(6).Layout(
-					giu.Label("Output already exists. Overwrite?"),
+			if showBackup {
+				giu.PopupModal("Backup:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Label("Drop a keyfile or small volume here to print it,"),
+					giu.Label("or drop scans of the printout to restore it"),
+					giu.Label(func() string {
+						if backupFile == "" {
+							return "Selected: none"
+						}
+						return "Selected: " + filepath.Base(backupFile)
+					}()),
+					giu.Separator(),
+					giu.Label("Words of a generated keyfile:"),
+					giu.InputTextMultiline(&keyfileWords).Size(316, 110),
 					giu.Row(
-						giu.Button("No").Size(100, 0).OnClick(func() {
-							giu.CloseCurrentPopup()
-							showOverwrite = false
+						giu.Button("Copy").Size(100, 0).OnClick(func() {
+							giu.Context.GetPlatform().SetClipboard(keyfileWords)
 						}),
-						giu.Button("Yes").Size(100, 0).OnClick(func() {
+						giu.Tooltip("Copy the words to the clipboard"),
+
+						giu.Button("Restore").Size(100, 0).OnClick(func() {
+							data, err := wordsToKeyfile(keyfileWords)
+							if err != nil {
+								mainStatus = "Invalid words (" + err.Error() + ")"
+								mainStatusColor = RED
+								giu.Update()
+								return
+							}
+							restoreBackup(data)
+						}),
+						giu.Tooltip("Save the keyfile that these words make"),
+					),
+					giu.Row(
+						giu.Style().SetDisabled(backupFile == "").To(
+							giu.Button("Print").Size(100, 0).OnClick(func() {
+								f := dialog.File().Title("Choose where to save the paper backup")
+								f.SetStartDir(filepath.Dir(backupFile))
+								f.SetInitFilename(filepath.Base(backupFile) + ".pdf")
+								file, err := f.Save()
+								if file == "" || err != nil {
+									return
+								}
+								if checksum, err := exportPaper(backupFile, file); err != nil {
+									mainStatus = "Failed to print (" + err.Error() + ")"
+									mainStatusColor = RED
+								} else {
+									mainStatus = "Printed, checksum " + checksum
+									mainStatusColor = GREEN
+								}
+								giu.Update()
+							}),
+							giu.Tooltip("Save QR codes of the file as a PDF or PNG to print"),
+						),
+
+						giu.Button("Done").Size(100, 0).OnClick(func() {
+							keyfileWords = ""
+							backupFile = ""
 							giu.CloseCurrentPopup()
-							showOverwrite = false
+							showBackup = false
+							showKeyfile = true
+							modalId++
+							giu.Update()
+						}),
+					),
+				).Build()
+				giu.OpenPopup("Backup:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
 
-							showProgress = true
-							fastDecode = true
-							canCancel = true
+			if showRecipients {
+				giu.PopupModal("Manage recipients:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Label(func() string {
+						if mode == "decrypt" {
+							return "Drag and drop your identity here"
+						}
+						return "Drag and drop recipients' public keys here"
+					}()),
+					giu.Custom(func() {
+						if len(recipients) > 0 {
+							giu.Separator().Build()
+						}
+						for _, i := range recipients {
+							if mode == "decrypt" {
+								giu.Label(i.Headers["Name"]).Build()
+							} else {
+								giu.Label(i.Headers["Name"] + " (" + fingerprint(i.Bytes) + ")").Build()
+							}
+						}
+					}),
+					giu.Row(
+						giu.Button("Clear").Size(100, 0).OnClick(func() {
+							recipients = nil
+							if recipient {
+								recipientLabel = "Identity required"
+							} else {
+								recipientLabel = "None selected"
+							}
 							modalId++
 							giu.Update()
-							go func() {
-								work()
-								working = false
-								showProgress = false
-								giu.Update()
-							}()
+						}),
+						giu.Tooltip("Remove all recipients"),
+
+						giu.Button("Done").Size(100, 0).OnClick(func() {
+							giu.CloseCurrentPopup()
+							showRecipients = false
 						}),
 					),
 				).Build()
-				giu.OpenPopup("Warning:##" + strconv.Itoa(modalId))
+				giu.OpenPopup("Manage recipients:##" + strconv.Itoa(modalId))
 				giu.Update()
 			}
 
-			if showProgress {
-				giu.PopupModal("Progress:##"+strconv.Itoa(modalId)).Flags(6|1<<0).Layout(
-					giu.Dummy(0, 0),
+			if showHidden {
+				giu.PopupModal("Hidden volume:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Label("Drag and drop the file to hide here"),
+					giu.Custom(func() {
+						if hiddenFile != "" {
+							giu.Separator().Build()
+							giu.Label(filepath.Base(hiddenFile)).Build()
+							giu.Separator().Build()
+						}
+					}),
+					giu.Label("Hidden password:"),
+					giu.InputText(&hiddenPassword).Flags(passwordState).Size(204),
+					giu.Label("Confirm hidden password:"),
+					giu.InputText(&hiddenCPassword).Flags(passwordState).Size(204),
 					giu.Row(
-						giu.ProgressBar(progress).Size(210, 0).Overlay(progressInfo),
-						giu.Style().SetDisabled(!canCancel).To(
-							giu.Button(func() string {
-								if working {
-									return "Cancel"
-								}
-								return "..."
-							}()).Size(58, 0).OnClick(func() {
-								working = false
-								canCancel = false
+						giu.Button("Cancel").Size(100, 0).OnClick(func() {
+							hiddenVolume = false
+							hiddenFile = ""
+							hiddenPassword = ""
+							hiddenCPassword = ""
+							giu.CloseCurrentPopup()
+							showHidden = false
+						}),
+						giu.Style().SetDisabled(hiddenFile == "" || hiddenPassword == "" || hiddenPassword != hiddenCPassword || hiddenPassword == password).To(
+							giu.Button("Done").Size(100, 0).OnClick(func() {
+								giu.CloseCurrentPopup()
+								showHidden = false
 							}),
 						),
 					),
-					giu.Label(popupStatus),
 				).Build()
-				giu.OpenPopup("Progress:##" + strconv.Itoa(modalId))
+				giu.OpenPopup("Hidden volume:##" + strconv.Itoa(modalId))
 				giu.Update()
 			}
-		}),
 
-		giu.Row(
-			giu.Label(inputLabel),
-			giu.Custom(func() {
-				bw, _ := giu.CalcTextSize("Clear")
-				p, _ := giu.GetWindowPadding()
-				bw += p * 2
-				giu.Dummy((bw+p)/-dpi, 0).Build()
-				giu.SameLine()
-				giu.Style().SetDisabled((len(allFiles) == 0 && len(onlyFiles) == 0) || scanning).To(
-					giu.Button("Clear").Size(bw/dpi, 0).OnClick(resetUI),
-					giu.Tooltip("Clear all input files and reset UI state"),
-				).Build()
-			}),
-		),
-
-		giu.Separator(),
-		giu.Style().SetDisabled((len(allFiles) == 0 && len(onlyFiles) == 0) || scanning).To(
-			giu.Label("Password:"),
-			giu.Row(
-				giu.Button(passwordStateLabel).Size(54, 0).OnClick(func() {
-					if passwordState == giu.InputTextFlagsPassword {
-						passwordState = giu.InputTextFlagsNone
-						passwordStateLabel = "Hide"
-					} else {
-						passwordState = giu.InputTextFlagsPassword
-						passwordStateLabel = "Show"
-					}
-					giu.Update()
-				}),
-				giu.Tooltip("Toggle the visibility of password entries"),
+			if showSigning {
+				giu.PopupModal("Signing keys:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Label("Drag and drop a signing key or public key here"),
+					giu.Custom(func() {
+						if mode != "decrypt" {
+							giu.Label("Signing with: " + signingKeyLabel).Build()
+						}
+						giu.Separator().Build()
+						giu.Label("Trusted signers:").Build()
+						signers := trustedSigners()
+						if len(signers) == 0 {
+							giu.Label("None").Build()
+						}
+						for _, i := range signers {
+							pub := i.Bytes
+							giu.Row(
+								giu.Button("Remove##"+fingerprint(pub)).OnClick(func() {
+									if err := untrustSigner(pub); err != nil {
+										mainStatus = "Failed to update trusted signers"
+										mainStatusColor = RED
+									}
+									giu.Update()
+								}),
+								giu.Label(i.Headers["Name"]+" ("+fingerprint(pub)+")"),
+							).Build()
+						}
+						giu.Separator().Build()
+					}),
+					giu.Row(
+						giu.Button("Create").Size(100, 0).OnClick(func() {
+							f := dialog.File().Title("Choose where to save the signing key")
+							f.SetInitFilename("signing-" + strconv.Itoa(int(time.Now().Unix())) + ".key")
+							file, err := f.Save()
+							if file == "" || err != nil {
+								return
+							}
+							name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+							key, err := createSigningKey(file, name)
+							if err != nil {
+								mainStatus = "Failed to create signing key"
+								mainStatusColor = RED
+								giu.Update()
+								return
+							}
+							if mode != "decrypt" {
+								signingKey = key
+								signingKeyLabel = name + " (" + fingerprint(key.Public().(ed25519.PublicKey)) + ")"
+							}
+							giu.Update()
+						}),
+						giu.Tooltip("Generate a signing key, and a .pub to give to others"),
 
-				giu.Button("Clear").Size(54, 0).OnClick(func() {
-					password = ""
-					cpassword = ""
-					giu.Update()
-				}),
-				giu.Tooltip("Clear the password entries"),
+						giu.Button("Done").Size(100, 0).OnClick(func() {
+							if signingKey == nil {
+								sign = false
+							}
+							giu.CloseCurrentPopup()
+							showSigning = false
+						}),
+					),
+				).Build()
+				giu.OpenPopup("Signing keys:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
 
-				giu.Button("Copy").Size(54, 0).OnClick(func() {
+			if showProfiles {
+				giu.PopupModal("Profiles:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Label("Save the current options, or apply saved ones"),
+					giu.Label("A profile called \"Default\" is used for every drop"),
+					giu.Custom(func() {
+						giu.Separator().Build()
+						list := profiles()
+						if len(list) == 0 {
+							giu.Label("None").Build()
+						}
+						for _, i := range list {
+							name := i.Name
+							giu.Row(
+								giu.Button("Apply##"+name).OnClick(func() {
+									applyProfile(&i)
+									giu.Update()
+								}),
+								giu.Button("Remove##"+name).OnClick(func() {
+									if err := removeProfile(name); err != nil {
+										mainStatus = "Failed to update profiles"
+										mainStatusColor = RED
+									}
+									giu.Update()
+								}),
+								giu.Label(name+" ("+profileSummary(&i)+")"),
+							).Build()
+						}
+						giu.Separator().Build()
+					}),
+					giu.Row(
+						giu.InputText(&profileName).Hint("Name of this profile").Size(204),
+						giu.Style().SetDisabled(strings.TrimSpace(profileName) == "").To(
+							giu.Button("Save").Size(100, 0).OnClick(func() {
+								if err := saveProfile(profileName); err != nil {
+									mainStatus = "Failed to save profile"
+									mainStatusColor = RED
+								}
+								profileName = ""
+								giu.Update()
+							}),
+							giu.Tooltip("Save the current options under this name"),
+						),
+					),
+					giu.Row(
+						giu.Button("Save as default").Size(204, 0).OnClick(func() {
+							if err := saveProfile(defaultProfile); err != nil {
+								mainStatus = "Failed to save profile"
+								mainStatusColor = RED
+							}
+							giu.Update()
+						}),
+						giu.Tooltip("Start from the current options every time"),
+
+						giu.Button("Done").Size(100, 0).OnClick(func() {
+							giu.CloseCurrentPopup()
+							showProfiles = false
+						}),
+					),
+				).Build()
+				giu.OpenPopup("Profiles:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
+
+			if showQueue {
+				giu.PopupModal("Queue:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Custom(func() {
+						// The buttons below change 'jobs' while it's locked
+						jobsLock.Lock()
+						defer jobsLock.Unlock()
+						if len(jobs) == 0 {
+							giu.Label("Add jobs here and prepare the next one while they run").Build()
+						}
+						for n, i := range jobs {
+							id := "##" + strconv.Itoa(i.id)
+							giu.Row(
+								giu.Style().SetDisabled(n == 0).To(
+									giu.Button("Up"+id).OnClick(func() {
+										jobs[n-1], jobs[n] = jobs[n], jobs[n-1]
+									}),
+								),
+								giu.Style().SetDisabled(n == len(jobs)-1).To(
+									giu.Button("Down"+id).OnClick(func() {
+										jobs[n], jobs[n+1] = jobs[n+1], jobs[n]
+									}),
+								),
+								giu.Style().SetDisabled(i.state != "Queued" && i.state != "Paused").To(
+									giu.Button(func() string {
+										if i.state == "Paused" {
+											return "Resume" + id
+										}
+										return "Pause" + id
+									}()).OnClick(func() {
+										if i.state == "Paused" {
+											i.state, i.status = "Queued", "Waiting"
+											if !jobsRunning {
+												jobsRunning = true
+												go runJobs()
+											}
+										} else {
+											i.state, i.status = "Paused", "Paused"
+										}
+									}),
+								),
+								giu.Button(func() string {
+									if i.state == "Running" {
+										return "Cancel" + id
+									}
+									return "Remove" + id
+								}()).OnClick(func() {
+									if i.state == "Running" {
+										i.state = "Cancelled"
+										if i.stdin != nil {
+											i.stdin.Close()
+										}
+										return
+									}
+									jobs = append(jobs[:n:n], jobs[n+1:]...)
+								}),
+								giu.Label(i.label),
+							).Build()
+							if i.state == "Running" {
+								giu.ProgressBar(i.progress).Size(giu.Auto, 0).Overlay(i.status).Build()
+							} else {
+								giu.Label(i.state + ": " + i.status).Build()
+							}
+						}
+						giu.Separator().Build()
+					}),
+					giu.Row(
+						giu.Style().SetDisabled(mode == "" || scanning).To(
+							giu.Button("Add current").Size(100, 0).OnClick(addJob),
+							giu.Tooltip("Queue the dropped files with the current options"),
+						),
+						giu.Button("Clear done").Size(100, 0).OnClick(func() {
+							jobsLock.Lock()
+							defer jobsLock.Unlock()
+							var tmp []*job
+							for _, i := range jobs {
+								if i.state != "Done" && i.state != "Failed" && i.state != "Cancelled" {
+									tmp = append(tmp, i)
+								}
+							}
+							jobs = tmp
+						}),
+						giu.Tooltip("Remove finished jobs from the list"),
+
+						giu.Button("Done").Size(100, 0).OnClick(func() {
+							giu.CloseCurrentPopup()
+							showQueue = false
+						}),
+					),
+					giu.Custom(func() {
+						if mainStatus != "Ready" {
+							giu.Style().SetColor(giu.StyleColorText, mainStatusColor).To(
+								giu.Label(mainStatus),
+							).Build()
+						}
+					}),
+				).Build()
+				giu.OpenPopup("Queue:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
+
+			if showResults {
+				giu.PopupModal("Results:##"+strconv.Itoa(modalId)).Flags(70).Layout(
+					giu.Custom(func() {
+						summary, color := batchSummary()
+						giu.Style().SetColor(giu.StyleColorText, color).To(
+							giu.Label(summary),
+						).Build()
+						var rows []giu.Widget
+						for _, i := range batchResults {
+							rows = append(rows,
+								giu.Label(i.Result+": "+filepath.Base(i.File)),
+								giu.Tooltip(i.File+"\n"+i.Status),
+							)
+						}
+						giu.Child().Border(true).Size(316, 160).Layout(rows...).Build()
+					}),
+					giu.Row(
+						giu.Button("Save CSV").Size(100, 0).OnClick(func() {
+							saveResults("results.csv")
+						}),
+						giu.Tooltip("Save the results as a spreadsheet"),
+
+						giu.Button("Save JSON").Size(100, 0).OnClick(func() {
+							saveResults("results.json")
+						}),
+						giu.Tooltip("Save the results for other programs"),
+
+						giu.Button("Done").Size(100, 0).OnClick(func() {
+							giu.CloseCurrentPopup()
+							showResults = false
+						}),
+					),
+				).Build()
+				giu.OpenPopup("Results:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
+
+			if showOverwrite {
+				giu.PopupModal("Warning:##"+strconv.Itoa(modalId)).Flags(6).Layout(
+					giu.Label("Output already exists. Overwrite?"),
+					giu.Row(
+						giu.Button("No").Size(100, 0).OnClick(func() {
+							giu.CloseCurrentPopup()
+							showOverwrite = false
+						}),
+						giu.Button("Yes").Size(100, 0).OnClick(func() {
+							giu.CloseCurrentPopup()
+							showOverwrite = false
+
+							showProgress = true
+							fastDecode = true
+							canCancel = true
+							modalId++
+							giu.Update()
+							go func() {
+								work()
+								working = false
+								showProgress = false
+								giu.Update()
+							}()
+						}),
+					),
+				).Build()
+				giu.OpenPopup("Warning:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
+
+			if showProgress {
+				giu.PopupModal("Progress:##"+strconv.Itoa(modalId)).Flags(6|1<<0).Layout(
+					giu.Dummy(0, 0),
+					giu.Row(
+						giu.ProgressBar(progress).Size(210, 0).Overlay(progressInfo),
+						giu.Style().SetDisabled(!canCancel).To(
+							giu.Button(func() string {
+								if working {
+									return "Cancel"
+								}
+								return "..."
+							}()).Size(58, 0).OnClick(func() {
+								working = false
+								canCancel = false
+							}),
+						),
+					),
+					giu.Label(popupStatus),
+				).Build()
+				giu.OpenPopup("Progress:##" + strconv.Itoa(modalId))
+				giu.Update()
+			}
+		}),
+
+		giu.Row(
+			giu.Label(inputLabel),
+			giu.Custom(func() {
+				bw, _ := giu.CalcTextSize("Clear")
+				p, _ := giu.GetWindowPadding()
+				bw += p * 2
+				giu.Dummy((bw+p)/-dpi, 0).Build()
+				giu.SameLine()
+				giu.Style().SetDisabled((len(allFiles) == 0 && len(onlyFiles) == 0) || scanning).To(
+					giu.Button("Clear").Size(bw/dpi, 0).OnClick(resetUI),
+					giu.Tooltip("Clear all input files and reset UI state"),
+				).Build()
+			}),
+		),
+
+		giu.Separator(),
+		giu.Style().SetDisabled((len(allFiles) == 0 && len(onlyFiles) == 0) || scanning).To(
+			giu.Label("Password:"),
+			giu.Row(
+				giu.Button(passwordStateLabel).Size(54, 0).OnClick(func() {
+					if passwordState == giu.InputTextFlagsPassword {
+						passwordState = giu.InputTextFlagsNone
+						passwordStateLabel = "Hide"
+					} else {
+						passwordState = giu.InputTextFlagsPassword
+						passwordStateLabel = "Show"
+					}
+					giu.Update()
+				}),
+				giu.Tooltip("Toggle the visibility of password entries"),
+
+				giu.Button("Clear").Size(54, 0).OnClick(func() {
+					password = ""
+					cpassword = ""
+					giu.Update()
+				}),
+				giu.Tooltip("Clear the password entries"),
+
+				giu.Button("Copy").Size(54, 0).OnClick(func() {
 					giu.Context.GetPlatform().SetClipboard(password)
 					giu.Update()
 				}),
@@ -626,10 +1389,50 @@ func draw() {
 					),
 				),
 			),
+			giu.Style().SetDisabled(mode == "decrypt" && !recipient && !deniability).To(
+				giu.Row(
+					giu.Label("Recipients:"),
+					giu.Button("Edit##recipients").Size(54, 0).OnClick(func() {
+						showRecipients = true
+						modalId++
+						giu.Update()
+					}),
+					giu.Tooltip(func() string {
+						if mode != "decrypt" {
+							return "Encrypt for others using their public keys instead of a password"
+						}
+						return "Choose your identity for decryption"
+					}()),
+
+					giu.Button("Create##identity").Size(54, 0).OnClick(func() {
+						f := dialog.File().Title("Choose where to save the identity")
+						f.SetStartDir(func() string {
+							if len(onlyFiles) > 0 {
+								return filepath.Dir(onlyFiles[0])
+							}
+							return filepath.Dir(onlyFolders[0])
+						}())
+						f.SetInitFilename("identity-" + strconv.Itoa(int(time.Now().Unix())) + ".key")
+						file, err := f.Save()
+						if file == "" || err != nil {
+							return
+						}
+						if err := createIdentity(file, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))); err != nil {
+							mainStatus = "Failed to create identity"
+							mainStatusColor = RED
+						}
+						giu.Update()
+					}),
+					giu.Tooltip("Generate an identity, and a .pub for others to encrypt to"),
+					giu.Style().SetDisabled(true).To(
+						giu.InputText(&recipientLabel).Size(giu.Auto),
+					),
+				),
+			),
 		),
 
 		giu.Separator(),
-		giu.Style().SetDisabled(mode != "decrypt" && ((len(keyfiles) == 0 && password == "") || (password != cpassword)) || deniability).To(
+		giu.Style().SetDisabled(mode != "decrypt" && ((len(keyfiles) == 0 && password == "" && len(recipients) == 0 && !shares) || (password != cpassword)) || deniability).To(
 			giu.Style().SetDisabled(mode == "decrypt" && (comments == "" || comments == "Comments are corrupted")).To(
 				giu.Label(commentsLabel),
 				giu.InputText(&comments).Size(giu.Auto).Flags(func() giu.InputTextFlags {
@@ -647,8 +1450,23 @@ func draw() {
 				}),
 			),
 		),
-		giu.Style().SetDisabled((len(keyfiles) == 0 && password == "") || (mode == "encrypt" && password != cpassword)).To(
-			giu.Label("Advanced:"),
+		giu.Style().SetDisabled((len(keyfiles) == 0 && password == "" && len(recipients) == 0 && !shares) || (mode == "encrypt" && password != cpassword)).To(
+			giu.Custom(func() {
+				if mode != "decrypt" {
+					giu.Row(
+						giu.Label("Advanced:"),
+						giu.Dummy(-170, 0),
+						giu.Button("Profiles").Size(giu.Auto, 0).OnClick(func() {
+							showProfiles = true
+							modalId++
+							giu.Update()
+						}),
+						giu.Tooltip("Save these options, or apply saved ones"),
+					).Build()
+				} else {
+					giu.Label("Advanced:").Build()
+				}
+			}),
 			giu.Custom(func() {
 				if mode != "decrypt" {
 					giu.Row(
@@ -681,6 +1499,36 @@ func draw() {
 						),
 					).Build()
 
+					giu.Row(
+						giu.Checkbox("Backup header", &backupHeader),
+						giu.Tooltip("Store a copy of the header at the end of the volume"),
+						giu.Dummy(-170, 0),
+						giu.Custom(func() {
+							// Hidden volumes can't be recursive, so random names take their place
+							if recursively {
+								giu.Style().SetDisabled(outputRoot == "").To(
+									giu.Checkbox("Random names", &randomNames),
+									giu.Tooltip("Give encrypted files random names, listed in an encrypted index"),
+								).Build()
+								return
+							}
+							giu.Style().SetDisabled(!deniability).To(
+								giu.Checkbox("Hidden volume", &hiddenVolume).OnChange(func() {
+									if hiddenVolume {
+										showHidden = true
+										modalId++
+									} else {
+										hiddenFile = ""
+										hiddenPassword = ""
+										hiddenCPassword = ""
+									}
+									giu.Update()
+								}),
+								giu.Tooltip("Hide another file behind a second password"),
+							).Build()
+						}),
+					).Build()
+
 					giu.Row(
 						giu.Checkbox("Split into chunks:", &split),
 						giu.Tooltip("Split the output file into smaller chunks"),
@@ -692,6 +1540,42 @@ func draw() {
 						giu.Combo("##splitter", splitUnits[splitSelected], splitUnits, &splitSelected).Size(68),
 						giu.Tooltip("Choose the chunk units"),
 					).Build()
+
+					giu.Row(
+						giu.Checkbox("Pad size:", &padding),
+						giu.Tooltip("Hide the exact size of the files with padding"),
+						giu.Dummy(-170, 0),
+						giu.Style().SetDisabled(padSelected == 3).To(
+							giu.InputText(&padSize).Size(86/dpi).Flags(2).OnChange(func() {
+								padding = padSize != ""
+							}),
+							giu.Tooltip("Choose the padding size"),
+						),
+						giu.Combo("##padder", padUnits[padSelected], padUnits, &padSelected).Size(68).OnChange(func() {
+							if padSelected == 3 {
+								padding = true
+							}
+						}),
+						giu.Tooltip("Round up to a multiple or power of 2 (2^n), or add up to this % at random"),
+					).Build()
+
+					giu.Row(
+						giu.Checkbox("Sign volume", &sign).OnChange(func() {
+							if sign && signingKey == nil {
+								showSigning = true
+								modalId++
+							}
+							giu.Update()
+						}),
+						giu.Tooltip("Sign with your key so others can tell it's from you"),
+						giu.Dummy(-170, 0),
+						giu.Button("Signing keys").Size(giu.Auto, 0).OnClick(func() {
+							showSigning = true
+							modalId++
+							giu.Update()
+						}),
+						giu.Tooltip("Choose your signing key and manage trusted signers"),
+					).Build()
 				} else {
 					giu.Row(
 						giu.Style().SetDisabled(deniability).To(
@@ -718,12 +1602,19 @@ func draw() {
 							giu.Tooltip("Extract .zip contents to same folder as volume"),
 						),
 					).Build()
+
+					giu.Button("Trusted signers").Size(giu.Auto, 0).OnClick(func() {
+						showSigning = true
+						modalId++
+						giu.Update()
+					}).Build()
+					giu.Tooltip("Manage whose signatures are trusted").Build()
 				}
 			}),
 
-			giu.Style().SetDisabled(recursively).To(
-				giu.Label("Save output as:"),
-				giu.Custom(func() {
+			giu.Custom(func() {
+				if recursively {
+					giu.Label("Save outputs in:").Build()
 					w, _ := giu.GetAvailableRegion()
 					bw, _ := giu.CalcTextSize("Change")
 					p, _ := giu.GetWindowPadding()
@@ -731,83 +1622,138 @@ func draw() {
 					dw := w - bw - p
 					giu.Style().SetDisabled(true).To(
 						giu.InputText(func() *string {
-							tmp := ""
-							if outputFile == "" {
-								return &tmp
-							}
-							tmp = filepath.Base(outputFile)
-							if split {
-								tmp += ".*"
-							}
-							if recursively {
-								tmp = "(multiple values)"
+							tmp := "(next to each file)"
+							if outputRoot != "" {
+								tmp = outputRoot
 							}
 							return &tmp
 						}()).Size(dw / dpi / dpi).Flags(16384),
 					).Build()
 
 					giu.SameLine()
-					giu.Button("Change").Size(bw/dpi, 0).OnClick(func() {
-						f := dialog.File().Title("Choose where to save the output. Don't include extensions")
-						f.SetStartDir(func() string {
-							if len(onlyFiles) > 0 {
-								return filepath.Dir(onlyFiles[0])
-							}
-							return filepath.Dir(onlyFolders[0])
-						}())
-
-						// Prefill the filename
-						tmp := strings.TrimSuffix(filepath.Base(outputFile), ".pcv")
-						f.SetInitFilename(strings.TrimSuffix(tmp, filepath.Ext(tmp)))
-						if mode == "encrypt" && (len(allFiles) > 1 || len(onlyFolders) > 0 || compress) {
-							f.SetInitFilename("encrypted-" + strconv.Itoa(int(time.Now().Unix())))
+					giu.Button("Change##root").Size(bw/dpi, 0).OnClick(func() {
+						f := dialog.Directory().Title("Choose where to save the outputs")
+						if outputRoot != "" {
+							f.SetStartDir(outputRoot)
 						}
-
-						// Get the chosen file path
-						file, err := f.Save()
-						if file == "" || err != nil {
+						dir, err := f.Browse()
+						if dir == "" || err != nil {
 							return
 						}
-						file = filepath.Join(filepath.Dir(file), strings.Split(filepath.Base(file), ".")[0])
-
-						// Add the correct extensions
-						if mode == "encrypt" {
-							if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
-								file += ".zip.pcv"
-							} else {
-								file += filepath.Ext(inputFile) + ".pcv"
-							}
-						} else {
-							if strings.HasSuffix(inputFile, ".zip.pcv") {
-								file += ".zip"
-							} else {
-								tmp := strings.TrimSuffix(filepath.Base(inputFile), ".pcv")
-								file += filepath.Ext(tmp)
-							}
-						}
-						outputFile = file
-						mainStatus = "Ready"
-						mainStatusColor = WHITE
+						outputRoot = dir
 						giu.Update()
 					}).Build()
-					giu.Tooltip("Save the output with a custom name and path").Build()
-				}),
-			),
-
-			giu.Dummy(0, 0),
-			giu.Separator(),
-			giu.Dummy(0, 0),
-			giu.Button(func() string {
-				if !recursively {
-					return startLabel
-				}
-				return "Process"
-			}()).Size(giu.Auto, 34).OnClick(onClickStartButton),
-			giu.Custom(func() {
-				if mainStatus != "Ready" {
-					giu.Style().SetColor(giu.StyleColorText, mainStatusColor).To(
-						giu.Label(mainStatus),
-					).Build()
+					giu.Tooltip("Save the outputs in another folder, with the same folder structure").Build()
+					return
+				}
+
+				giu.Label("Save output as:").Build()
+				w, _ := giu.GetAvailableRegion()
+				bw, _ := giu.CalcTextSize("Change")
+				p, _ := giu.GetWindowPadding()
+				bw += p * 2
+				dw := w - bw - p
+				giu.Style().SetDisabled(true).To(
+					giu.InputText(func() *string {
+						tmp := ""
+						if outputFile == "" {
+							return &tmp
+						}
+						tmp = filepath.Base(outputFile)
+						if split {
+							tmp += ".*"
+						}
+						return &tmp
+					}()).Size(dw / dpi / dpi).Flags(16384),
+				).Build()
+
+				giu.SameLine()
+				giu.Button("Change").Size(bw/dpi, 0).OnClick(func() {
+					f := dialog.File().Title("Choose where to save the output. Don't include extensions")
+					f.SetStartDir(func() string {
+						if len(onlyFiles) > 0 {
+							return filepath.Dir(onlyFiles[0])
+						}
+						return filepath.Dir(onlyFolders[0])
+					}())
+
+					// Prefill the filename
+					tmp := strings.TrimSuffix(filepath.Base(outputFile), ".pcv")
+					f.SetInitFilename(strings.TrimSuffix(tmp, filepath.Ext(tmp)))
+					if mode == "encrypt" && (len(allFiles) > 1 || len(onlyFolders) > 0 || compress) {
+						f.SetInitFilename("encrypted-" + strconv.Itoa(int(time.Now().Unix())))
+					}
+
+					// Get the chosen file path
+					file, err := f.Save()
+					if file == "" || err != nil {
+						return
+					}
+					file = filepath.Join(filepath.Dir(file), strings.Split(filepath.Base(file), ".")[0])
+
+					// Add the correct extensions
+					if mode == "encrypt" {
+						if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+							file += ".zip.pcv"
+						} else {
+							file += filepath.Ext(inputFile) + ".pcv"
+						}
+					} else {
+						if strings.HasSuffix(inputFile, ".zip.pcv") {
+							file += ".zip"
+						} else {
+							tmp := strings.TrimSuffix(filepath.Base(inputFile), ".pcv")
+							file += filepath.Ext(tmp)
+						}
+					}
+					outputFile = file
+					mainStatus = "Ready"
+					mainStatusColor = WHITE
+					giu.Update()
+				}).Build()
+				giu.Tooltip("Save the output with a custom name and path").Build()
+			}),
+
+			giu.Dummy(0, 0),
+			giu.Separator(),
+			giu.Dummy(0, 0),
+			giu.Custom(func() {
+				w, _ := giu.GetAvailableRegion()
+				bw, _ := giu.CalcTextSize("Queue (00)")
+				p, _ := giu.GetWindowPadding()
+				bw += p * 2
+				giu.Button(func() string {
+					if !recursively {
+						return startLabel
+					}
+					return "Process"
+				}()).Size((w-bw-p)/dpi, 34).OnClick(onClickStartButton).Build()
+				giu.SameLine()
+				giu.Button(func() string {
+					jobsLock.Lock()
+					defer jobsLock.Unlock()
+					queued := 0
+					for _, i := range jobs {
+						if i.state == "Queued" || i.state == "Running" {
+							queued++
+						}
+					}
+					if queued == 0 {
+						return "Queue"
+					}
+					return fmt.Sprintf("Queue (%d)", queued)
+				}()).Size(bw/dpi, 34).OnClick(func() {
+					showQueue = true
+					modalId++
+					giu.Update()
+				}).Build()
+				giu.Tooltip("Queue jobs to run one after another in the background").Build()
+			}),
+			giu.Custom(func() {
+				if mainStatus != "Ready" {
+					giu.Style().SetColor(giu.StyleColorText, mainStatusColor).To(
+						giu.Label(mainStatus),
+					).Build()
 					return
 				}
 				if requiredFreeSpace > 0 {
@@ -815,9 +1761,6 @@ func draw() {
 					if len(allFiles) > 1 || len(onlyFolders) > 0 { // need a temporary zip file
 						multiplier++
 					}
-					if deniability {
-						multiplier++
-					}
 					if split {
 						multiplier++
 					}
@@ -827,8 +1770,12 @@ func draw() {
 					if autoUnzip {
 						multiplier++
 					}
+					size := requiredFreeSpace
+					if mode == "encrypt" && padding {
+						size += maxPaddingFor(size)
+					}
 					giu.Style().SetColor(giu.StyleColorText, WHITE).To(
-						giu.Label("Ready (ensure >" + sizeify(requiredFreeSpace*int64(multiplier)) + " of disk space is free)"),
+						giu.Label("Ready (ensure >" + sizeify(size*int64(multiplier)) + " of disk space is free)"),
 					).Build()
 				} else {
 					giu.Style().SetColor(giu.StyleColorText, WHITE).To(
@@ -845,8 +1792,119 @@ func draw() {
 }
 
 func onDrop(names []string) {
+	if showBackup {
+		// Scans of a paper backup are restored, anything else is what to back up
+		data, err := importPaper(names)
+		if err == nil {
+			restoreBackup(data)
+			return
+		}
+		if !errors.Is(err, errNoPaper) && !errors.Is(err, image.ErrFormat) {
+			mainStatus = "Unable to restore (" + err.Error() + ")"
+			mainStatusColor = RED
+		} else {
+			backupFile = names[0]
+			keyfileWords, _ = exportWords(names[0])
+		}
+		modalId++
+		giu.Update()
+		return
+	}
+
+	if showSigning {
+		for _, name := range names {
+			if key, keyName, err := readSigningKey(name); err == nil {
+				if mode != "decrypt" {
+					signingKey = key
+					signingKeyLabel = keyName + " (" + fingerprint(key.Public().(ed25519.PublicKey)) + ")"
+				}
+			} else if _, err := readPublicKey(name); err != nil {
+				mainStatus = "Not a signing key or public key"
+				mainStatusColor = RED
+			} else if _, err := trustSigner(name); err != nil {
+				mainStatus = "Failed to update trusted signers"
+				mainStatusColor = RED
+			}
+		}
+		modalId++
+		giu.Update()
+		return
+	}
+
+	if showRecipients {
+		for _, name := range names {
+			var block *pem.Block
+			var err error
+			if mode == "decrypt" {
+				block, err = readIdentity(name)
+			} else {
+				block, err = readRecipient(name)
+			}
+			if err != nil {
+				if mode == "decrypt" {
+					mainStatus = "Not an identity"
+				} else {
+					mainStatus = "Not a recipient's public key"
+				}
+				mainStatusColor = RED
+				continue
+			}
+
+			// Skip duplicates
+			duplicate := false
+			for _, i := range recipients {
+				if bytes.Equal(i.Bytes, block.Bytes) {
+					duplicate = true
+				}
+			}
+			if !duplicate {
+				recipients = append(recipients, block)
+			}
+		}
+
+		// Update the recipient status
+		if len(recipients) == 0 && recipient {
+			recipientLabel = "Identity required"
+		} else if len(recipients) == 0 {
+			recipientLabel = "None selected"
+		} else if mode == "decrypt" {
+			recipientLabel = fmt.Sprintf("Using %d identities", len(recipients))
+			if len(recipients) == 1 {
+				recipientLabel = "Using 1 identity"
+			}
+		} else {
+			recipientLabel = fmt.Sprintf("%d recipients", len(recipients))
+			if len(recipients) == 1 {
+				recipientLabel = "1 recipient"
+			}
+		}
+
+		modalId++
+		giu.Update()
+		return
+	}
+
+	if showHidden {
+		stat, err := os.Stat(names[0])
+		if err == nil && !stat.IsDir() {
+			hiddenFile = names[0]
+		}
+		modalId++
+		giu.Update()
+		return
+	}
+
 	if showKeyfile {
+		names, err := expandKeyfiles(names)
+		if err != nil {
+			showKeyfile = false
+			resetUI()
+			accessDenied("Keyfile read")
+			giu.Update()
+			return
+		}
 		keyfiles = append(keyfiles, names...)
+		shares = false
 
 		// Make sure keyfiles are accessible, remove duplicates
 		var tmp []string
@@ -895,9 +1953,29 @@ func onDrop(names []string) {
 
 	// One item dropped
 	if len(names) == 1 {
-		stat, err := os.Stat(names[0])
+		// A remote file is opened once, and stat and read through the same connection
+		var remote inputReader
+		var stat os.FileInfo
+		var err error
+		if isRemote(names[0]) {
+			var r inputReader
+			if r, err = openInput(names[0]); err == nil {
+				remote = r
+				stat, err = statInput(remote)
+			}
+			defer func() {
+				if remote != nil {
+					remote.Close()
+				}
+			}()
+		} else {
+			stat, err = os.Stat(names[0])
+		}
 		if err != nil {
 			mainStatus = "Failed to stat dropped item"
+			if isRemote(names[0]) {
+				mainStatus += " (" + err.Error() + ")"
+			}
 			mainStatusColor = RED
 			giu.Update()
 			return
@@ -957,13 +2035,20 @@ func onDrop(names []string) {
 					outputFile = names[0][:len(names[0])-4]
 				}
 
+				// A volume downloaded from S3 is decrypted into the current folder
+				if isRemote(outputFile) {
+					outputFile = path.Base(outputFile)
+				}
+
 				// Open the input file in read-only mode
-				var fin *os.File
+				var fin inputReader
 				var err error
 				if isSplit {
 					fin, err = os.Open(names[0] + ".0")
+				} else if remote != nil {
+					fin, remote = remote, nil
 				} else {
-					fin, err = os.Open(names[0])
+					fin, err = openInput(names[0])
 				}
 				if err != nil {
 					resetUI()
@@ -972,12 +2057,34 @@ func onDrop(names []string) {
 					return
 				}
 
+				// Use the backup header at the end of the volume if the primary one is damaged
+				hdr := io.Reader(fin)
+				var backup []byte
+				if isSplit {
+					chunks, err := openChunks(inputFile)
+					if err == nil {
+						backup = readBackupHeader(chunks, chunks.size)
+						chunks.Close()
+					}
+				} else {
+					backup = readBackupHeader(fin, stat.Size())
+				}
+				if backup != nil && !headerIntact(fin) {
+					hdr = bytes.NewReader(backup)
+					mainStatus = "Primary header is damaged, using backup header"
+					mainStatusColor = YELLOW
+				}
+				if _, err := fin.Seek(0, 0); err != nil {
+					panic(err)
+				}
+
 				// Check if version can be read from header
 				tmp := make([]byte, 15)
-				if n, err := fin.Read(tmp); err != nil || n != 15 {
+				if n, err := hdr.Read(tmp); err != nil || n != 15 {
 					fin.Close()
 					mainStatus = "Failed to read 15 bytes from file"
 					mainStatusColor = RED
+					mainOutcome = outcomeCorrupted
 					giu.Update()
 					return
 				}
@@ -991,10 +2098,11 @@ func onDrop(names []string) {
 				} else {
 					// Read comments from file and check for corruption
 					tmp = make([]byte, 15)
-					if n, err := fin.Read(tmp); err != nil || n != 15 {
+					if n, err := hdr.Read(tmp); err != nil || n != 15 {
 						fin.Close()
 						mainStatus = "Failed to read 15 bytes from file"
 						mainStatusColor = RED
+						mainOutcome = outcomeCorrupted
 						giu.Update()
 						return
 					}
@@ -1006,10 +2114,11 @@ func onDrop(names []string) {
 							giu.Update()
 						} else {
 							tmp = make([]byte, commentsLength*3)
-							if n, err := fin.Read(tmp); err != nil || n != commentsLength*3 {
+							if n, err := io.ReadFull(hdr, tmp); err != nil || n != commentsLength*3 {
 								fin.Close()
 								mainStatus = "Failed to read comments from file"
 								mainStatusColor = RED
+								mainOutcome = outcomeCorrupted
 								giu.Update()
 								return
 							}
@@ -1031,10 +2140,11 @@ func onDrop(names []string) {
 
 					// Read flags from file and check for corruption
 					flags := make([]byte, 15)
-					if n, err := fin.Read(flags); err != nil || n != 15 {
+					if n, err := hdr.Read(flags); err != nil || n != 15 {
 						fin.Close()
 						mainStatus = "Failed to read 15 bytes from file"
 						mainStatusColor = RED
+						mainOutcome = outcomeCorrupted
 						giu.Update()
 						return
 					}
@@ -1045,6 +2155,14 @@ func onDrop(names []string) {
 					if err != nil {
 						mainStatus = "The volume header is damaged"
 						mainStatusColor = RED
+						mainOutcome = outcomeCorrupted
+						giu.Update()
+						return
+					}
+					if !knownFlags(flags) {
+						mainStatus = "The volume needs a newer version of Picocrypt"
+						mainStatusColor = RED
+						mainOutcome = outcomeFailed
 						giu.Update()
 						return
 					}
@@ -1053,12 +2171,21 @@ func onDrop(names []string) {
 					if flags[1] == 1 {
 						keyfile = true
 						keyfileLabel = "Keyfiles required"
+					} else if flags[1] == 2 {
+						keyfile = true
+						keyfileLabel = "Shares required"
 					} else {
 						keyfileLabel = "Not applicable"
 					}
 					if flags[2] == 1 {
 						keyfileOrdered = true
 					}
+					if flags[0]&16 == 16 {
+						recipient = true
+						recipientLabel = "Identity required"
+					} else {
+						recipientLabel = "Not applicable"
+					}
 					giu.Update()
 				}
 			} else { // One file was dropped for encryption
@@ -1130,10 +2257,16 @@ func onDrop(names []string) {
 		giu.Update()
 	}
 
+	// Start from the options saved as the default
+	if profile := findProfile(defaultProfile); profile != nil && mode == "encrypt" {
+		applyProfile(profile)
+	}
+
 	// Recursively add all files in 'onlyFolders' to 'allFiles'
-	go func() {
+	dropped := onlyFolders
+	walk := func() {
 		oldInputLabel := inputLabel
-		for _, name := range onlyFolders {
+		for _, name := range dropped {
 			if filepath.Walk(name, func(path string, _ os.FileInfo, err error) error {
 				if err != nil {
 					resetUI()
@@ -1170,15 +2303,25 @@ func onDrop(names []string) {
 		inputLabel = fmt.Sprintf("%s (%s)", oldInputLabel, sizeify(compressTotal))
 		scanning = false
 		giu.Update()
-	}()
+	}
+
+	// Without folders, finish now so a later drop isn't mixed up with this one
+	if len(dropped) == 0 {
+		walk()
+	} else {
+		go walk()
+	}
 }
 
 func work() {
 	popupStatus = "Starting..."
 	mainStatus = "Working..."
 	mainStatusColor = WHITE
+	mainOutcome = outcomeFailed
 	working = true
 	padded := false
+	blockMACs := false
+	batched := false
 	giu.Update()
 
 	// Cryptography values
@@ -1186,12 +2329,15 @@ func work() {
 	var hkdfSalt []byte                // HKDF-SHA3 salt, 32 bytes
 	var serpentIV []byte               // Serpent IV, 16 bytes
 	var nonce []byte                   // 24-byte XChaCha20 nonce
-	var keyHash []byte                 // SHA3-512 hash of encryption key
-	var keyHashRef []byte              // Same as 'keyHash', but used for comparison
+	var keyHashRef []byte              // SHA3-512 hash of encryption key, used for comparison
 	var keyfileKey []byte              // The SHA3-256 hashes of keyfiles
 	var keyfileHash = make([]byte, 32) // The SHA3-256 of 'keyfileKey'
 	var keyfileHashRef []byte          // Same as 'keyfileHash', but used for comparison
 	var authTag []byte                 // 64-byte authentication tag (BLAKE2b or HMAC-SHA3)
+	var signedHeader []byte            // Header values covered by the signature
+	var signer []byte                  // Ed25519 public key of the sender, if signed
+	var signature []byte               // Ed25519 signature of the sender
+	var stanzas [][]byte               // Random volume key wrapped for each recipient
 
 	var tempZipCipherW *chacha20.Cipher
 	var tempZipCipherR *chacha20.Cipher
@@ -1234,6 +2380,9 @@ func work() {
 
 		// Open a temporary .zip for writing
 		inputFile = strings.TrimSuffix(outputFile, ".pcv") + ".tmp"
+		if isRemote(outputFile) {
+			inputFile = filepath.Join(rootDir, path.Base(inputFile))
+		}
 		file, err := os.Create(inputFile)
 		if err != nil { // Make sure file is writable
 			accessDenied("Write")
@@ -1324,6 +2473,20 @@ func work() {
 				return
 			}
 		}
+
+		// List the files that an incremental backup no longer has
+		if deletedFiles != nil {
+			entry, err := writer.Create(deletedList)
+			if err == nil {
+				_, err = entry.Write([]byte(strings.Join(deletedFiles, "\n")))
+			}
+			if err != nil {
+				writer.Close()
+				insufficientSpace(nil, file)
+				os.Remove(inputFile)
+				return
+			}
+		}
 		if err := writer.Close(); err != nil {
 			panic(err)
 		}
@@ -1332,6 +2495,12 @@ func work() {
 		}
 	}
 
+	// Mounting reads a split volume's chunks in place instead
+	chunked := recombine && sealKey != nil
+	if chunked {
+		recombine = false
+	}
+
 	// Recombine a split file if necessary
 	if recombine {
 		totalFiles := 0
@@ -1416,159 +2585,84 @@ func work() {
 		inputFile = outputFile + ".pcv"
 	}
 
-	// Input volume has plausible deniability
-	if mode == "decrypt" && deniability {
-		popupStatus = "Removing deniability protection..."
-		progressInfo = ""
-		progress = 0
-		canCancel = false
-		giu.Update()
-
-		// Get size of volume for showing progress
-		stat, err := os.Stat(inputFile)
-		if err != nil {
-			// we already read from inputFile successfully in onDrop
-			// so it is very unlikely this err != nil, we can just panic
-			panic(err)
-		}
-		total := stat.Size()
-
-		// Rename input volume to free up the filename
-		fin, err := os.Open(inputFile)
-		if err != nil {
-			panic(err)
-		}
-		for strings.HasSuffix(inputFile, ".tmp") {
-			inputFile = strings.TrimSuffix(inputFile, ".tmp")
-		}
-		inputFile += ".tmp"
-		fout, err := os.Create(inputFile)
-		if err != nil {
-			panic(err)
-		}
-
-		// Get the Argon2 salt and XChaCha20 nonce from input volume
-		salt := make([]byte, 16)
-		nonce := make([]byte, 24)
-		if n, err := fin.Read(salt); err != nil || n != 16 {
-			panic(errors.New("failed to read 16 bytes from file"))
-		}
-		if n, err := fin.Read(nonce); err != nil || n != 24 {
-			panic(errors.New("failed to read 24 bytes from file"))
-		}
+	canCancel = false
+	progress = 0
+	progressInfo = ""
+	giu.Update()
 
-		// Generate key and XChaCha20
-		key := argon2.IDKey([]byte(password), salt, 4, 1<<20, 4, 32)
-		chacha, err := chacha20.NewUnauthenticatedCipher(key, nonce)
-		if err != nil {
-			panic(err)
-		}
+	// Open input file in read-only mode
+	var input inputReader
+	var err error
+	if chunked {
+		input, err = openChunks(inputFile)
+	} else {
+		input, err = openInput(inputFile)
+	}
+	if err != nil {
+		resetUI()
+		accessDenied("Read")
+		return
+	}
 
-		// Decrypt the entire volume
-		done, counter := 0, 0
-		for {
-			src := make([]byte, MiB)
-			size, err := fin.Read(src)
-			if err != nil {
-				break
-			}
-			src = src[:size]
-			dst := make([]byte, len(src))
-			chacha.XORKeyStream(dst, src)
-			if n, err := fout.Write(dst); err != nil || n != len(dst) {
-				fout.Close()
-				os.Remove(fout.Name())
-				panic(errors.New("failed to write dst"))
-			}
+	// Subtract the header size from the total size if decrypting
+	stat, err := statInput(input)
+	if err != nil {
+		input.Close()
+		resetUI()
+		accessDenied("Read")
+		return
+	}
+	total := stat.Size()
+	var fin inputReader = input
 
-			// Update stats
-			done += size
-			counter += MiB
-			progress = float32(float64(done) / float64(total))
+	// Input volume has plausible deniability, so remove it as the volume is read
+	var denyIn *deniableFile
+	if mode == "decrypt" && deniability {
+		// The outer layer uses the keyfiles too
+		var denyKeyfileKey []byte
+		if len(keyfiles) > 0 {
+			popupStatus = "Reading keyfiles..."
 			giu.Update()
-
-			// Change nonce after 60 GiB to prevent overflow
-			if counter >= 60*GiB {
-				tmp := sha3.New256()
-				if n, err := tmp.Write(nonce); err != nil || n != len(nonce) {
-					panic(errors.New("failed to write nonce to tmp during rekeying"))
-				}
-				nonce = tmp.Sum(nil)[:24]
-				chacha, err = chacha20.NewUnauthenticatedCipher(key, nonce)
-				if err != nil {
-					panic(err)
+			denyKeyfileKey, err = readKeyfiles(keyfiles, keyfileOrdered)
+			progress = 0
+			if err != nil {
+				broken(input, nil, "Unable to combine shares ("+err.Error()+")", true)
+				mainOutcome = outcomeWrongPassword
+				if recombine {
+					inputFile = inputFileOld
 				}
-				counter = 0
+				return
 			}
 		}
 
-		if err := fin.Close(); err != nil {
-			panic(err)
-		}
-		if err := fout.Close(); err != nil {
-			panic(err)
-		}
-
-		// Check if the version can be read from the volume
-		fin, err = os.Open(inputFile)
-		if err != nil {
-			panic(err)
-		}
-		tmp := make([]byte, 15)
-		if n, err := fin.Read(tmp); err != nil || n != 15 {
-			panic(errors.New("failed to read 15 bytes from file"))
-		}
-		if err := fin.Close(); err != nil {
-			panic(err)
-		}
-		tmp, err = rsDecode(rs5, tmp)
-		if valid, _ := regexp.Match(`^v1\.\d{2}`, tmp); err != nil || !valid {
-			os.Remove(inputFile)
-			inputFile = strings.TrimSuffix(inputFile, ".tmp")
-			broken(nil, nil, "Password is incorrect or the file is not a volume", true)
+		// Find the decoy or hidden volume that the password and keyfiles unlock
+		layer := findDeniable(input, total, password, denyKeyfileKey)
+		if layer == nil {
+			broken(input, nil, "Incorrect password/keyfiles or not a volume", true)
+			mainOutcome = outcomeWrongPassword
 			if recombine {
 				inputFile = inputFileOld
 			}
 			return
 		}
+		denyIn = newDeniableFile(layer, input)
+		fin = denyIn
+		total = layer.length
 	}
-
-	canCancel = false
-	progress = 0
-	progressInfo = ""
-	giu.Update()
-
-	// Subtract the header size from the total size if decrypting
-	stat, err := os.Stat(inputFile)
-	if err != nil {
-		resetUI()
-		accessDenied("Read")
-		return
-	}
-	total := stat.Size()
+	size := total
 	if mode == "decrypt" {
 		total -= 789
 	}
-
-	// Open input file in read-only mode
-	fin, err := os.Open(inputFile)
-	if err != nil {
-		resetUI()
-		accessDenied("Read")
-		return
-	}
+	var payload io.Reader = fin
 
 	// Setup output file
-	var fout *os.File
+	var output *os.File
+	var fout outputWriter
+	var denyOut *deniableWriter
+	var upload remoteWriter
 
 	// If encrypting, generate values and write to file
 	if mode == "encrypt" {
-		popupStatus = "Generating values..."
-		giu.Update()
-
-		// Stores any errors when writing to file
-		errs := make([]error, 11)
-
 		// Make sure not to overwrite anything
 		_, err = os.Stat(outputFile)
 		if split && err == nil { // File already exists
@@ -1581,171 +2675,215 @@ func work() {
 			return
 		}
 
-		// Create the output file
-		fout, err = os.Create(outputFile + ".incomplete")
-		if err != nil {
-			fin.Close()
-			if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
-				os.Remove(inputFile)
+		// Upload straight to the server as one object
+		if isRemote(outputFile) {
+			if deniability || shares {
+				err = errors.New("deniability and shares need a local output")
+			} else if split {
+				err = errors.New("splitting needs a local output")
+			} else {
+				upload, err = createRemote(outputFile, s3PartSizeFor(total))
 			}
-			accessDenied("Write")
-			return
-		}
-
-		// Set up cryptographic values
-		salt = make([]byte, 16)
-		hkdfSalt = make([]byte, 32)
-		serpentIV = make([]byte, 16)
-		nonce = make([]byte, 24)
-
-		// Write the program version to file
-		_, errs[0] = fout.Write(rsEncode(rs5, []byte(version)))
-
-		if len(comments) > 99999 {
-			panic(errors.New("comments exceed maximum length"))
-		}
-
-		// Encode and write the comment length to file
-		commentsLength := []byte(fmt.Sprintf("%05d", len(comments)))
-		_, errs[1] = fout.Write(rsEncode(rs5, commentsLength))
-
-		// Encode the comment and write to file
-		for _, i := range []byte(comments) {
-			_, err := fout.Write(rsEncode(rs1, []byte{i}))
 			if err != nil {
-				errs[2] = err
+				fin.Close()
+				if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+					os.Remove(inputFile)
+				}
+				mainStatus = "Can't upload (" + err.Error() + ")"
+				mainStatusColor = RED
+				return
 			}
 		}
 
-		// Configure flags and write to file
-		flags := make([]byte, 5)
-		if paranoid { // Paranoid mode selected
-			flags[0] = 1
-		}
-		if len(keyfiles) > 0 { // Keyfiles are being used
-			flags[1] = 1
-		}
-		if keyfileOrdered { // Order of keyfiles matter
-			flags[2] = 1
-		}
-		if reedsolo { // Full Reed-Solomon encoding is selected
-			flags[3] = 1
-		}
-		if total%int64(MiB) >= int64(MiB)-128 { // Reed-Solomon internals
-			flags[4] = 1
-		}
-		_, errs[3] = fout.Write(rsEncode(rs5, flags))
-
-		// Fill values with Go's CSPRNG
-		if _, err := rand.Read(salt); err != nil {
-			panic(err)
-		}
-		if _, err := rand.Read(hkdfSalt); err != nil {
-			panic(err)
-		}
-		if _, err := rand.Read(serpentIV); err != nil {
-			panic(err)
-		}
-		if _, err := rand.Read(nonce); err != nil {
-			panic(err)
-		}
-		if bytes.Equal(salt, make([]byte, 16)) {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-		if bytes.Equal(hkdfSalt, make([]byte, 32)) {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-		if bytes.Equal(serpentIV, make([]byte, 16)) {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-		if bytes.Equal(nonce, make([]byte, 24)) {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-
-		// Encode values with Reed-Solomon and write to file
-		_, errs[4] = fout.Write(rsEncode(rs16, salt))
-		_, errs[5] = fout.Write(rsEncode(rs32, hkdfSalt))
-		_, errs[6] = fout.Write(rsEncode(rs16, serpentIV))
-		_, errs[7] = fout.Write(rsEncode(rs24, nonce))
-
-		// Write placeholders for future use
-		_, errs[8] = fout.Write(make([]byte, 192))  // Hash of encryption key
-		_, errs[9] = fout.Write(make([]byte, 96))   // Hash of keyfile key
-		_, errs[10] = fout.Write(make([]byte, 192)) // BLAKE2b/HMAC-SHA3 tag
-
-		for _, err := range errs {
+		// Create the output file
+		if upload != nil {
+			fout = upload
+		} else {
+			output, err = os.Create(outputFile + ".incomplete")
 			if err != nil {
-				insufficientSpace(fin, fout)
+				fin.Close()
 				if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
 					os.Remove(inputFile)
 				}
-				os.Remove(fout.Name())
+				accessDenied("Write")
 				return
 			}
+			fout = output
+		}
+
+		// Add plausible deniability as the volume is written
+		if deniability {
+			denyOut = newDeniableWriter(output, false)
+			fout = denyOut
+		}
+
+		// Encrypt the input, or the temporary .zip of it, into the volume
+		var source io.Reader = payload
+		if tempZipInUse {
+			source = &encryptedZipReader{
+				_r:      fin,
+				_cipher: tempZipCipherR,
+			}
+		}
+		keyfileKey, err = encryptVolume(source, total, fout, denyOut, currentOptions())
+		if err != nil {
+			encryptFailed(err, fin, fout)
+			if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+				os.Remove(inputFile)
+			}
+			os.Remove(fout.Name())
+			return
 		}
 	} else { // Decrypting, read values from file and decode
 		popupStatus = "Reading values..."
 		giu.Update()
 
+		// Use the backup header at the end of the volume if the primary one is damaged
+		hdr := io.Reader(fin)
+		backup := readBackupHeader(fin, size)
+		if backup != nil && !headerIntact(fin) {
+			hdr = bytes.NewReader(backup)
+		}
+		if _, err := fin.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
 		// Stores any Reed-Solomon decoding errors
-		errs := make([]error, 10)
+		errs := make([]error, 14)
 
 		version := make([]byte, 15)
-		fin.Read(version)
-		_, errs[0] = rsDecode(rs5, version)
+		hdr.Read(version)
+		version, errs[0] = rsDecode(rs5, version)
 
 		tmp := make([]byte, 15)
-		fin.Read(tmp)
+		hdr.Read(tmp)
 		tmp, errs[1] = rsDecode(rs5, tmp)
-
 		if valid, err := regexp.Match(`^\d{5}$`, tmp); !valid || err != nil {
 			broken(fin, nil, "Unable to read comments length", true)
 			return
 		}
 
 		commentsLength, _ := strconv.Atoi(string(tmp))
-		fin.Read(make([]byte, commentsLength*3))
+		tmp = make([]byte, commentsLength*3)
+		io.ReadFull(hdr, tmp)
+		var commentBytes []byte
+		for i := 0; i < len(tmp); i += 3 {
+			t, _ := rsDecode(rs1, tmp[i:i+3])
+			commentBytes = append(commentBytes, t...)
+		}
 		total -= int64(commentsLength) * 3
 
 		flags := make([]byte, 15)
-		fin.Read(flags)
+		hdr.Read(flags)
 		flags, errs[2] = rsDecode(rs5, flags)
-		paranoid = flags[0] == 1
-		reedsolo = flags[3] == 1
+		if errs[2] == nil && !knownFlags(flags) {
+			broken(fin, nil, "The volume needs a newer version of Picocrypt", true)
+			mainOutcome = outcomeFailed
+			return
+		}
+		paranoid = flags[0]&1 == 1
+		padding = flags[0]&4 == 4
+		reedsolo = flags[3]&1 == 1
+		blockMACs = flags[3]&2 == 2
 		padded = flags[4] == 1
+		recipient = flags[0]&16 == 16
+		batched = flags[0]&32 == 32
 		if deniability {
-			keyfile = flags[1] == 1
+			keyfile = flags[1] != 0
 			keyfileOrdered = flags[2] == 1
 		}
 
 		salt = make([]byte, 48)
-		fin.Read(salt)
+		hdr.Read(salt)
 		salt, errs[3] = rsDecode(rs16, salt)
 
 		hkdfSalt = make([]byte, 96)
-		fin.Read(hkdfSalt)
+		hdr.Read(hkdfSalt)
 		hkdfSalt, errs[4] = rsDecode(rs32, hkdfSalt)
 
 		serpentIV = make([]byte, 48)
-		fin.Read(serpentIV)
+		hdr.Read(serpentIV)
 		serpentIV, errs[5] = rsDecode(rs16, serpentIV)
 
 		nonce = make([]byte, 72)
-		fin.Read(nonce)
+		hdr.Read(nonce)
 		nonce, errs[6] = rsDecode(rs24, nonce)
 
 		keyHashRef = make([]byte, 192)
-		fin.Read(keyHashRef)
+		hdr.Read(keyHashRef)
 		keyHashRef, errs[7] = rsDecode(rs64, keyHashRef)
 
 		keyfileHashRef = make([]byte, 96)
-		fin.Read(keyfileHashRef)
+		hdr.Read(keyfileHashRef)
 		keyfileHashRef, errs[8] = rsDecode(rs32, keyfileHashRef)
 
 		authTag = make([]byte, 192)
-		fin.Read(authTag)
+		hdr.Read(authTag)
 		authTag, errs[9] = rsDecode(rs64, authTag)
 
+		if flags[0]&8 == 8 {
+			signer = make([]byte, 96)
+			hdr.Read(signer)
+			signer, errs[10] = rsDecode(rs32, signer)
+
+			signature = make([]byte, 192)
+			hdr.Read(signature)
+			signature, errs[11] = rsDecode(rs64, signature)
+		}
+		signedHeader = bytes.Join([][]byte{
+			version, []byte(fmt.Sprintf("%05d", commentsLength)), commentBytes, flags, salt, hkdfSalt, serpentIV, nonce,
+		}, nil)
+
+		// The volume key wrapped for each recipient comes last
+		count := 0
+		if recipient {
+			tmp = make([]byte, 15)
+			hdr.Read(tmp)
+			tmp, errs[12] = rsDecode(rs5, tmp)
+			count, _ = strconv.Atoi(string(tmp))
+			signedHeader = append(signedHeader, tmp...)
+			for range count {
+				stanza := make([]byte, stanzaSize*3)
+				if _, err := io.ReadFull(hdr, stanza); err != nil {
+					errs[13] = err
+					break
+				}
+				for i := 0; i < len(stanza); i += 96 {
+					tmp, err := rsDecode(rs32, stanza[i:i+96])
+					if err != nil {
+						errs[13] = err
+					}
+					copy(stanza[i/3:], tmp)
+				}
+				stanzas = append(stanzas, stanza[:stanzaSize])
+				signedHeader = append(signedHeader, stanza[:stanzaSize]...)
+			}
+		}
+
+		// The public key, signature, and recipients come after the authentication tag
+		headerSize := int64(789 + commentsLength*3)
+		if flags[0]&8 == 8 {
+			total -= 288
+			headerSize += 288
+		}
+		if recipient {
+			total -= int64(15 + count*stanzaSize*3)
+			headerSize += int64(15 + count*stanzaSize*3)
+		}
+
+		// Don't treat the backup header at the end as part of the payload
+		if flags[0]&2 == 2 {
+			total -= headerSize + 48
+			payload = io.LimitReader(fin, total)
+		}
+
+		// Skip over the primary header if the backup was used
+		if hdr != io.Reader(fin) {
+			if _, err := fin.Seek(int64(len(backup)), 0); err != nil {
+				panic(err)
+			}
+		}
+
 		// If there was an issue during decoding, the header is corrupted
 		for _, err := range errs {
 			if err != nil {
@@ -1757,152 +2895,80 @@ func work() {
 				}
 			}
 		}
-	}
-
-	popupStatus = "Deriving key..."
-	giu.Update()
-
-	// Derive encryption keys and subkeys
-	var key []byte
-	if paranoid {
-		key = argon2.IDKey(
-			[]byte(password),
-			salt,
-			8,     // 8 passes
-			1<<20, // 1 GiB memory
-			8,     // 8 threads
-			32,    // 32-byte output key
-		)
-	} else {
-		key = argon2.IDKey(
-			[]byte(password),
-			salt,
-			4,
-			1<<20,
-			4,
-			32,
-		)
-	}
-	if bytes.Equal(key, make([]byte, 32)) {
-		panic(errors.New("fatal crypto/argon2 error"))
-	}
-
-	// If keyfiles are being used
-	if len(keyfiles) > 0 || keyfile {
-		popupStatus = "Reading keyfiles..."
-		giu.Update()
 
-		var keyfileTotal int64
-		for _, path := range keyfiles {
-			stat, err := os.Stat(path)
-			if err != nil {
-				panic(err) // we already checked os.Stat in onDrop
+		// Check the sender's signature over the header and authentication tag
+		if signer != nil {
+			message := signedMessage(signedHeader, keyHashRef, keyfileHashRef, authTag)
+			if !ed25519.Verify(signer, message, signature) {
+				if keep {
+					kept = true
+					signer = nil
+				} else {
+					broken(fin, nil, "The signature is invalid", true)
+					return
+				}
 			}
-			keyfileTotal += stat.Size()
 		}
 
-		if keyfileOrdered { // If order matters, hash progressively
-			var tmp = sha3.New256()
-			var keyfileDone int
+		popupStatus = "Deriving key..."
+		giu.Update()
 
-			// For each keyfile...
-			for _, path := range keyfiles {
-				fin, err := os.Open(path)
-				if err != nil {
-					panic(err)
-				}
-				for { // Read in chunks of 1 MiB
-					data := make([]byte, MiB)
-					size, err := fin.Read(data)
-					if err != nil {
-						break
+		// Derive encryption keys and subkeys
+		var key []byte
+		if recipient {
+			// Find the stanza that one of the identities can unwrap
+			for _, i := range recipients {
+				for _, stanza := range stanzas {
+					tmp := unwrapKey(stanza, i.Bytes)
+					if tmp == nil {
+						continue
 					}
-					data = data[:size]
-					if _, err := tmp.Write(data); err != nil { // Hash the data
-						panic(err)
+					hash := sha3.Sum512(tmp)
+					if subtle.ConstantTimeCompare(hash[:], keyHashRef) == 1 {
+						key = tmp
 					}
-
-					// Update progress
-					keyfileDone += size
-					progress = float32(keyfileDone) / float32(keyfileTotal)
-					giu.Update()
 				}
-				if err := fin.Close(); err != nil {
-					panic(err)
+			}
+			if key == nil {
+				broken(fin, nil, "The identity can't decrypt this volume", true)
+				mainOutcome = outcomeWrongPassword
+				if recombine {
+					inputFile = inputFileOld
 				}
+				return
 			}
-			keyfileKey = tmp.Sum(nil) // Get the SHA3-256
-
-			// Store a hash of 'keyfileKey' for comparison
-			tmp = sha3.New256()
-			if _, err := tmp.Write(keyfileKey); err != nil {
-				panic(err)
+		} else {
+			key = deriveKey(password, salt, paranoid)
+			if batched {
+				key = batchSubkey(key, hkdfSalt)
 			}
-			keyfileHash = tmp.Sum(nil)
-		} else { // If order doesn't matter, hash individually and combine
-			var keyfileDone int
-
-			// For each keyfile...
-			for _, path := range keyfiles {
-				fin, err := os.Open(path)
-				if err != nil {
-					panic(err)
-				}
-				tmp := sha3.New256()
-				for { // Read in chunks of 1 MiB
-					data := make([]byte, MiB)
-					size, err := fin.Read(data)
-					if err != nil {
-						break
-					}
-					data = data[:size]
-					if _, err := tmp.Write(data); err != nil { // Hash the data
-						panic(err)
-					}
-
-					// Update progress
-					keyfileDone += size
-					progress = float32(keyfileDone) / float32(keyfileTotal)
-					giu.Update()
-				}
-				if err := fin.Close(); err != nil {
-					panic(err)
-				}
+		}
 
-				sum := tmp.Sum(nil) // Get the SHA3-256
+		// If keyfiles are being used
+		if len(keyfiles) > 0 || keyfile {
+			popupStatus = "Reading keyfiles..."
+			giu.Update()
 
-				// XOR keyfile hash with 'keyfileKey'
-				if keyfileKey == nil {
-					keyfileKey = sum
-				} else {
-					for i, j := range sum {
-						keyfileKey[i] ^= j
-					}
+			if keyfileKey, err = readKeyfiles(keyfiles, keyfileOrdered); err != nil {
+				broken(fin, nil, "Unable to combine shares ("+err.Error()+")", true)
+				mainOutcome = outcomeWrongPassword
+				if recombine {
+					inputFile = inputFileOld
 				}
+				return
 			}
 
 			// Store a hash of 'keyfileKey' for comparison
-			tmp := sha3.New256()
-			if _, err := tmp.Write(keyfileKey); err != nil {
-				panic(err)
-			}
-			keyfileHash = tmp.Sum(nil)
+			tmp := sha3.Sum256(keyfileKey)
+			keyfileHash = tmp[:]
 		}
-	}
-
-	popupStatus = "Calculating values..."
-	giu.Update()
 
-	// Hash the encryption key for comparison when decrypting
-	tmp := sha3.New512()
-	if _, err := tmp.Write(key); err != nil {
-		panic(err)
-	}
-	keyHash = tmp.Sum(nil)
+		popupStatus = "Calculating values..."
+		giu.Update()
 
-	// Validate the password and/or keyfiles
-	if mode == "decrypt" {
-		keyCorrect := subtle.ConstantTimeCompare(keyHash, keyHashRef) == 1
+		// Validate the password and/or keyfiles
+		keyHash := sha3.Sum512(key)
+		keyCorrect := subtle.ConstantTimeCompare(keyHash[:], keyHashRef) == 1
 		keyfileCorrect := subtle.ConstantTimeCompare(keyfileHash, keyfileHashRef) == 1
 		incorrect := !keyCorrect
 		if keyfile || len(keyfiles) > 0 {
@@ -1922,13 +2988,9 @@ func work() {
 					} else {
 						mainStatus = "Incorrect keyfiles"
 					}
-					if deniability {
-						fin.Close()
-						os.Remove(inputFile)
-						inputFile = strings.TrimSuffix(inputFile, ".tmp")
-					}
 				}
 				broken(fin, nil, mainStatus, true)
+				mainOutcome = outcomeWrongPassword
 				if recombine {
 					inputFile = inputFileOld
 				}
@@ -1936,302 +2998,190 @@ func work() {
 			}
 		}
 
-		// Create the output file for decryption
-		fout, err = os.Create(outputFile + ".incomplete")
-		if err != nil {
-			fin.Close()
-			if recombine {
-				os.Remove(inputFile)
+		if len(keyfiles) > 0 || keyfile {
+			// Prevent an even number of duplicate keyfiles
+			if bytes.Equal(keyfileKey, make([]byte, 32)) {
+				mainStatus = "Duplicate keyfiles detected"
+				mainStatusColor = RED
+				fin.Close()
+				return
 			}
-			accessDenied("Write")
+
+			// XOR the encryption key with the keyfile key
+			key = xorKeys(key, keyfileKey)
+		}
+
+		// When mounting, read a volume with per-block MACs in place, a block at a time. Signed
+		// volumes are still decrypted in full, since the signature only covers the whole MAC.
+		if sealKey != nil && blockMACs && signer == nil && !kept {
+			c := newVolumeCipher(key, nonce, serpentIV, hkdfSalt, paranoid, blockMACs, padding)
+			openedPayload, err = newVolumeReader(fin, headerSize, total, c, authTag, nonce, serpentIV, paranoid, reedsolo, padded)
+			if err != nil {
+				broken(fin, nil, "The input file is damaged or modified", true)
+				return
+			}
+			resetUI()
+			mainOutcome = outcomeSuccess
+			mainStatus = "Completed"
+			mainStatusColor = GREEN
 			return
 		}
-	}
 
-	if len(keyfiles) > 0 || keyfile {
-		// Prevent an even number of duplicate keyfiles
-		if bytes.Equal(keyfileKey, make([]byte, 32)) {
-			mainStatus = "Duplicate keyfiles detected"
-			mainStatusColor = RED
+		// Create the output file for decryption
+		if isRemote(outputFile) {
 			fin.Close()
-			if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+			if recombine {
 				os.Remove(inputFile)
 			}
-			fout.Close()
-			os.Remove(fout.Name())
+			mainStatus = "Decrypted files can't be uploaded"
+			mainStatusColor = RED
 			return
 		}
-
-		// XOR the encryption key with the keyfile key
-		tmp := key
-		key = make([]byte, 32)
-		for i := range key {
-			key[i] = tmp[i] ^ keyfileKey[i]
-		}
-	}
-
-	done, counter := 0, 0
-	chacha, err := chacha20.NewUnauthenticatedCipher(key, nonce)
-	if err != nil {
-		panic(err)
-	}
-
-	// Use HKDF-SHA3 to generate a subkey for the MAC
-	var mac hash.Hash
-	subkey := make([]byte, 32)
-	hkdf := hkdf.New(sha3.New256, key, hkdfSalt, nil)
-	if n, err := hkdf.Read(subkey); err != nil || n != 32 {
-		panic(errors.New("fatal hkdf.Read error"))
-	}
-	if paranoid {
-		mac = hmac.New(sha3.New512, subkey) // HMAC-SHA3
-	} else {
-		mac, err = blake2b.New512(subkey) // Keyed BLAKE2b
+		output, err = os.Create(outputFile + ".incomplete")
 		if err != nil {
-			panic(err)
-		}
-	}
-
-	// Generate another subkey for use as Serpent's key
-	serpentKey := make([]byte, 32)
-	if n, err := hkdf.Read(serpentKey); err != nil || n != 32 {
-		panic(errors.New("fatal hkdf.Read error"))
-	}
-	s, err := serpent.NewCipher(serpentKey)
-	if err != nil {
-		panic(err)
-	}
-	serpent := cipher.NewCTR(s, serpentIV)
-
-	// Start the main encryption process
-	canCancel = true
-	startTime := time.Now()
-	tempZip := encryptedZipReader{
-		_r:      fin,
-		_cipher: tempZipCipherR,
-	}
-	for {
-		if !working {
-			cancel(fin, fout)
-			if recombine || len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+			fin.Close()
+			if recombine {
 				os.Remove(inputFile)
 			}
-			os.Remove(fout.Name())
+			accessDenied("Write")
 			return
 		}
-
-		// Read in data from the file
-		var src []byte
-		if mode == "decrypt" && reedsolo {
-			src = make([]byte, MiB/128*136)
-		} else {
-			src = make([]byte, MiB)
-		}
-
-		var size int
-		if tempZipInUse {
-			size, err = tempZip.Read(src)
-		} else {
-			size, err = fin.Read(src)
-		}
-		if err != nil {
-			break
+		fout = output
+		if sealKey != nil {
+			fout = &sealedFile{file: output, key: sealKey}
 		}
-		src = src[:size]
-		dst := make([]byte, len(src))
 
-		// Do the actual encryption
-		if mode == "encrypt" {
-			if paranoid {
-				serpent.XORKeyStream(dst, src)
-				copy(src, dst)
+		// Start the main decryption process
+		c := newVolumeCipher(key, nonce, serpentIV, hkdfSalt, paranoid, blockMACs, padding)
+		canCancel = true
+		startTime := time.Now()
+		done := 0
+		for index := int64(0); ; index++ {
+			if !working {
+				cancel(fin, fout)
+				if recombine {
+					os.Remove(inputFile)
+				}
+				os.Remove(fout.Name())
+				return
 			}
 
-			chacha.XORKeyStream(dst, src)
-			if _, err := mac.Write(dst); err != nil {
-				panic(err)
+			// Read in a whole block from the file, along with its MAC
+			var src []byte
+			if reedsolo {
+				src = make([]byte, MiB/128*136)
+			} else {
+				src = make([]byte, MiB)
 			}
+			if blockMACs && reedsolo {
+				src = append(src, make([]byte, 48)...)
+			} else if blockMACs {
+				src = append(src, make([]byte, 16)...)
+			}
+			size, err := io.ReadFull(payload, src)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				break
+			}
+			src = src[:size]
+			last := done+size >= int(total)
+
+			// Separate the block's MAC from its data
+			var blockTag []byte
+			tagErr := errors.New("no per-block MAC")
+			if blockMACs && reedsolo && len(src) > 48 {
+				blockTag, tagErr = rsDecode(rs16, src[len(src)-48:])
+				src = src[:len(src)-48]
+			} else if blockMACs && !reedsolo && len(src) > 16 {
+				blockTag, tagErr = src[len(src)-16:], nil
+				src = src[:len(src)-16]
+			}
+			dst := make([]byte, len(src))
 
 			if reedsolo {
-				copy(src, dst)
-				dst = nil
-				// If a full MiB is available
-				if len(src) == MiB {
-					// Encode every chunk
-					for i := 0; i < MiB; i += 128 {
-						dst = append(dst, rsEncode(rs128, src[i:i+128])...)
-					}
-				} else {
-					// Encode the full chunks
-					chunks := math.Floor(float64(len(src)) / 128)
-					for i := 0; float64(i) < chunks; i++ {
-						dst = append(dst, rsEncode(rs128, src[i*128:(i+1)*128])...)
+				encoded := src
+
+				// With per-block MACs, only fully decode the blocks that don't match
+				repairing := !fastDecode
+				src, err = rsDecodeBlock(encoded, last && padded, !repairing)
+				if tagErr == nil && !hmac.Equal(c.blockMAC(index, last, src), blockTag) {
+					repairing = true
+					src, err = rsDecodeBlock(encoded, last && padded, false)
+					if err == nil && !hmac.Equal(c.blockMAC(index, last, src), blockTag) {
+						err = errors.New("block MAC mismatch")
 					}
-
-					// Pad and encode the final partial chunk
-					dst = append(dst, rsEncode(rs128, pad(src[int(chunks*128):]))...)
 				}
-			}
-		} else { // Decryption
-			if reedsolo {
-				copy(dst, src)
-				src = nil
-				// If a complete 1 MiB block is available
-				if len(dst) == MiB/128*136 {
-					// Decode every chunk
-					for i := 0; i < MiB/128*136; i += 136 {
-						tmp, err := rsDecode(rs128, dst[i:i+136])
-						if err != nil {
-							if keep {
-								kept = true
-							} else {
-								broken(fin, fout, "The input file is irrecoverably damaged", false)
-								return
-							}
-						}
-						if i == MiB/128*136-136 && done+MiB/128*136 >= int(total) && padded {
-							tmp = unpad(tmp)
-						}
-						src = append(src, tmp...)
-
-						if !fastDecode && i%17408 == 0 {
-							progress, speed, eta = statify(int64(done+i), total, startTime)
-							progressInfo = fmt.Sprintf("%.2f%%", progress*100)
-							popupStatus = fmt.Sprintf("Repairing at %.2f MiB/s (ETA: %s)", speed, eta)
-							giu.Update()
-						}
-					}
-				} else {
-					// Decode the full chunks
-					chunks := len(dst)/136 - 1
-					for i := range chunks {
-						tmp, err := rsDecode(rs128, dst[i*136:(i+1)*136])
-						if err != nil {
-							if keep {
-								kept = true
-							} else {
-								broken(fin, fout, "The input file is irrecoverably damaged", false)
-								return
-							}
-						}
-						src = append(src, tmp...)
-
-						if !fastDecode && i%128 == 0 {
-							progress, speed, eta = statify(int64(done+i*136), total, startTime)
-							progressInfo = fmt.Sprintf("%.2f%%", progress*100)
-							popupStatus = fmt.Sprintf("Repairing at %.2f MiB/s (ETA: %s)", speed, eta)
-							giu.Update()
-						}
-					}
-
-					// Unpad and decode the final partial chunk
-					tmp, err := rsDecode(rs128, dst[int(chunks)*136:])
-					if err != nil {
-						if keep {
-							kept = true
-						} else {
-							broken(fin, fout, "The input file is irrecoverably damaged", false)
-							return
-						}
+				if err != nil {
+					if keep {
+						kept = true
+					} else {
+						broken(fin, fout, "The input file is irrecoverably damaged", false)
+						return
 					}
-					src = append(src, unpad(tmp)...)
+				}
+				if repairing {
+					progress, speed, eta = statify(int64(done), total, startTime)
+					progressInfo = fmt.Sprintf("%.2f%%", progress*100)
+					popupStatus = fmt.Sprintf("Repairing at %.2f MiB/s (ETA: %s)", speed, eta)
+					giu.Update()
 				}
 				dst = make([]byte, len(src))
+			} else if blockMACs && (tagErr != nil || !hmac.Equal(c.blockMAC(index, last, src), blockTag)) {
+				// Without Reed-Solomon, a block that doesn't match can't be fixed, so stop here
+				if keep {
+					kept = true
+				} else {
+					broken(fin, fout, "The input file is damaged or modified", false)
+					return
+				}
 			}
 
-			if _, err := mac.Write(src); err != nil {
+			if _, err := c.mac.Write(src); err != nil {
 				panic(err)
 			}
-			chacha.XORKeyStream(dst, src)
+			c.chacha.XORKeyStream(dst, src)
 
 			if paranoid {
 				copy(src, dst)
-				serpent.XORKeyStream(dst, src)
+				c.serpent.XORKeyStream(dst, src)
 			}
-		}
 
-		// Write the data to output file
-		_, err = fout.Write(dst)
-		if err != nil {
-			insufficientSpace(fin, fout)
-			if recombine || len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
-				os.Remove(inputFile)
+			// Write the data to output file
+			if _, err := fout.Write(dst); err != nil {
+				insufficientSpace(fin, fout)
+				if recombine {
+					os.Remove(inputFile)
+				}
+				os.Remove(fout.Name())
+				return
 			}
-			os.Remove(fout.Name())
-			return
-		}
 
-		// Update stats
-		if mode == "decrypt" && reedsolo {
-			done += MiB / 128 * 136
-		} else {
-			done += MiB
-		}
-		counter += MiB
-		progress, speed, eta = statify(int64(done), total, startTime)
-		progressInfo = fmt.Sprintf("%.2f%%", progress*100)
-		if mode == "encrypt" {
-			popupStatus = fmt.Sprintf("Encrypting at %.2f MiB/s (ETA: %s)", speed, eta)
-		} else {
+			// Update stats
+			done += size
+			progress, speed, eta = statify(int64(done), total, startTime)
+			progressInfo = fmt.Sprintf("%.2f%%", progress*100)
 			if fastDecode {
 				popupStatus = fmt.Sprintf("Decrypting at %.2f MiB/s (ETA: %s)", speed, eta)
 			}
+			giu.Update()
+			c.advance()
 		}
+
+		progress = 0
+		progressInfo = ""
+		popupStatus = "Comparing values..."
 		giu.Update()
 
-		// Change nonce/IV after 60 GiB to prevent overflow
-		if counter >= 60*GiB {
-			// ChaCha20
-			nonce = make([]byte, 24)
-			if n, err := hkdf.Read(nonce); err != nil || n != 24 {
-				panic(errors.New("fatal hkdf.Read error"))
-			}
-			chacha, err = chacha20.NewUnauthenticatedCipher(key, nonce)
-			if err != nil {
+		// Authenticate the padding flag so it can't be flipped to truncate the output
+		if padding {
+			if _, err := c.mac.Write([]byte("padded")); err != nil {
 				panic(err)
 			}
-
-			// Serpent
-			serpentIV = make([]byte, 16)
-			if n, err := hkdf.Read(serpentIV); err != nil || n != 16 {
-				panic(errors.New("fatal hkdf.Read error"))
-			}
-			serpent = cipher.NewCTR(s, serpentIV)
-
-			// Reset counter to 0
-			counter = 0
 		}
-	}
-
-	progress = 0
-	progressInfo = ""
-	giu.Update()
-
-	if mode == "encrypt" {
-		popupStatus = "Writing values..."
-		giu.Update()
-
-		// Seek back to header and write important values
-		if _, err := fout.Seek(int64(309+len(comments)*3), 0); err != nil {
-			panic(err)
-		}
-		if _, err := fout.Write(rsEncode(rs64, keyHash)); err != nil {
-			panic(err)
-		}
-		if _, err := fout.Write(rsEncode(rs32, keyfileHash)); err != nil {
-			panic(err)
-		}
-		if _, err := fout.Write(rsEncode(rs64, mac.Sum(nil))); err != nil {
-			panic(err)
-		}
-	} else {
-		popupStatus = "Comparing values..."
-		giu.Update()
 
 		// Validate the authenticity of decrypted data
-		if subtle.ConstantTimeCompare(mac.Sum(nil), authTag) == 0 {
+		if subtle.ConstantTimeCompare(c.mac.Sum(nil), authTag) == 0 {
 			// Decrypt again but this time rebuilding the input data
-			if reedsolo && fastDecode {
+			if reedsolo && fastDecode && !blockMACs {
 				fastDecode = false
 				fin.Close()
 				fout.Close()
@@ -2246,6 +3196,56 @@ func work() {
 				return
 			}
 		}
+
+		// The outer layer of a deniable volume has its own MAC
+		if denyIn != nil && !denyIn.verify() {
+			broken(fin, fout, "The volume is damaged or modified", false)
+			return
+		}
+
+		// Strip the size-hiding padding, using the length at the very end
+		if padding {
+			stat, err := output.Stat()
+			if err != nil {
+				panic(err)
+			}
+			tmp := make([]byte, 8)
+			fout.ReadAt(tmp, stat.Size()-8)
+			padLen := int64(binary.LittleEndian.Uint64(tmp))
+			if padLen >= 0 && padLen <= stat.Size()-8 {
+				if err := output.Truncate(stat.Size() - 8 - padLen); err != nil {
+					panic(err)
+				}
+			} else if keep {
+				kept = true
+			} else {
+				broken(fin, fout, "The input file is damaged or modified", false)
+				return
+			}
+		}
+	}
+
+	// Finish the outer layer of a deniable volume
+	if denyOut != nil {
+		if err := denyOut.finish(); err != nil {
+			insufficientSpace(fin, fout)
+			if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+				os.Remove(inputFile)
+			}
+			os.Remove(fout.Name())
+			return
+		}
+	}
+
+	// Complete the upload, which is when the volume appears on the server
+	if upload != nil {
+		if err := upload.finish(); err != nil {
+			insufficientSpace(fin, fout)
+			if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+				os.Remove(inputFile)
+			}
+			return
+		}
 	}
 
 	if err := fin.Close(); err != nil {
@@ -2255,113 +3255,79 @@ func work() {
 		panic(err)
 	}
 
-	if err := os.Rename(outputFile+".incomplete", outputFile); err != nil {
-		panic(err)
-	}
-
-	// Add plausible deniability
+	// The decoy volume is followed by either the hidden volume or random padding
 	if mode == "encrypt" && deniability {
-		popupStatus = "Adding plausible deniability..."
-		canCancel = false
-		giu.Update()
-
-		// Get size of volume for showing progress
-		stat, err := os.Stat(outputFile)
-		if err != nil {
-			panic(err)
-		}
-		total := stat.Size()
-
-		// Rename the output volume to free up the filename
-		os.Rename(outputFile, outputFile+".tmp")
-		fin, err := os.Open(outputFile + ".tmp")
-		if err != nil {
-			panic(err)
-		}
-		fout, err := os.Create(outputFile + ".incomplete")
-		if err != nil {
-			panic(err)
-		}
-
-		// Use a random Argon2 salt and XChaCha20 nonce
-		salt := make([]byte, 16)
-		nonce := make([]byte, 24)
-		if n, err := rand.Read(salt); err != nil || n != 16 {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-		if n, err := rand.Read(nonce); err != nil || n != 24 {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-		if bytes.Equal(salt, make([]byte, 16)) || bytes.Equal(nonce, make([]byte, 24)) {
-			panic(errors.New("fatal crypto/rand error"))
-		}
-		if _, err := fout.Write(salt); err != nil {
-			panic(err)
-		}
-		if _, err := fout.Write(nonce); err != nil {
-			panic(err)
-		}
-
-		// Generate key and XChaCha20
-		key := argon2.IDKey([]byte(password), salt, 4, 1<<20, 4, 32)
-		if bytes.Equal(key, make([]byte, 32)) {
-			panic(errors.New("fatal crypto/argon2 error"))
-		}
-		chacha, err := chacha20.NewUnauthenticatedCipher(key, nonce)
-		if err != nil {
-			panic(err)
-		}
-
-		// Encrypt the entire volume
-		done, counter := 0, 0
-		for {
-			src := make([]byte, MiB)
-			size, err := fin.Read(src)
+		decoyEnd := denyOut.layer.offset + denyOut.layer.length + 64
+		maxTail := 80 + 64 + denyOut.layer.length/4
+		if hiddenVolume && hiddenFile != "" {
+			ok := encryptHidden()
+			stat, err := os.Stat(outputFile + ".incomplete")
+			if ok && err == nil && stat.Size()-decoyEnd > maxTail {
+				// A hidden volume larger than the padding could be would give itself away
+				ok = false
+				mainStatus = "Hidden file must be under 1/4 of the decoy size"
+				mainStatusColor = RED
+			}
+			if !ok {
+				os.Remove(outputFile + ".incomplete")
+				if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+					os.Remove(inputFile)
+				}
+				return
+			}
+		} else {
+			// Random padding, indistinguishable from a hidden volume
+			tail, err := rand.Int(rand.Reader, big.NewInt(maxTail-80-64+1))
 			if err != nil {
-				break
+				panic(err)
 			}
-			src = src[:size]
-			dst := make([]byte, len(src))
-			chacha.XORKeyStream(dst, src)
-			if _, err := fout.Write(dst); err != nil {
+			fout, err := os.OpenFile(outputFile+".incomplete", os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
 				panic(err)
 			}
-
-			// Update stats
-			done += size
-			counter += MiB
-			progress = float32(float64(done) / float64(total))
-			giu.Update()
-
-			// Change nonce after 60 GiB to prevent overflow
-			if counter >= 60*GiB {
-				tmp := sha3.New256()
-				if _, err := tmp.Write(nonce); err != nil {
+			for remaining := tail.Int64() + 80 + 64; remaining > 0; {
+				data := make([]byte, min(MiB, remaining))
+				if _, err := rand.Read(data); err != nil {
 					panic(err)
 				}
-				nonce = tmp.Sum(nil)[:24]
-				chacha, err = chacha20.NewUnauthenticatedCipher(key, nonce)
-				if err != nil {
-					panic(err)
+				if _, err := fout.Write(data); err != nil {
+					insufficientSpace(nil, fout)
+					if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+						os.Remove(inputFile)
+					}
+					os.Remove(fout.Name())
+					return
 				}
-				counter = 0
+				remaining -= int64(len(data))
+			}
+			if err := fout.Close(); err != nil {
+				panic(err)
 			}
 		}
+	}
 
-		if err := fin.Close(); err != nil {
-			panic(err)
-		}
-		if err := fout.Close(); err != nil {
-			panic(err)
-		}
-		if err := os.Remove(fin.Name()); err != nil {
-			panic(err)
+	// Write the shares of the keyfile key for the user to hand out
+	if mode == "encrypt" && shares {
+		for i, share := range splitSecret(keyfileKey, int(sharesNeeded), int(sharesTotal)) {
+			if err := writeShare(sharePath(i+1), share, int(sharesTotal)); err != nil {
+				for j := range i {
+					os.Remove(sharePath(j + 1))
+				}
+				os.Remove(outputFile + ".incomplete")
+				if len(allFiles) > 1 || len(onlyFolders) > 0 || compress {
+					os.Remove(inputFile)
+				}
+				accessDenied("Write")
+				return
+			}
 		}
+	}
+
+	// An upload is already in place on the server
+	if upload == nil {
 		if err := os.Rename(outputFile+".incomplete", outputFile); err != nil {
 			panic(err)
 		}
-		canCancel = true
-		giu.Update()
 	}
 
 	// Split the file into chunks
@@ -2374,23 +3340,7 @@ func work() {
 		size := stat.Size()
 		finishedFiles := 0
 		finishedBytes := 0
-		chunkSize, err := strconv.Atoi(splitSize)
-		if err != nil {
-			panic(err)
-		}
-
-		// Calculate chunk size
-		if splitSelected == 0 {
-			chunkSize *= KiB
-		} else if splitSelected == 1 {
-			chunkSize *= MiB
-		} else if splitSelected == 2 {
-			chunkSize *= GiB
-		} else if splitSelected == 3 {
-			chunkSize *= TiB
-		} else {
-			chunkSize = int(math.Ceil(float64(size) / float64(chunkSize)))
-		}
+		chunkSize := int(splitChunkSize(size))
 
 		// Get the number of required chunks
 		chunks := int(math.Ceil(float64(size) / float64(chunkSize)))
@@ -2511,9 +3461,6 @@ func work() {
 		if err := os.Remove(inputFile); err != nil {
 			panic(err)
 		}
-		if deniability {
-			os.Remove(strings.TrimSuffix(inputFile, ".tmp"))
-		}
 	}
 
 	// Delete the input files if the user chooses
@@ -2538,11 +3485,6 @@ func work() {
 				if err := os.Remove(inputFile); err != nil {
 					panic(err)
 				}
-				if deniability {
-					if err := os.Remove(strings.TrimSuffix(inputFile, ".tmp")); err != nil {
-						panic(err)
-					}
-				}
 			}
 		} else {
 			for _, i := range onlyFiles {
@@ -2557,9 +3499,6 @@ func work() {
 			}
 		}
 	}
-	if mode == "decrypt" && deniability {
-		os.Remove(inputFile)
-	}
 
 	if mode == "decrypt" && !kept && autoUnzip {
 		showProgress = true
@@ -2584,35 +3523,536 @@ func work() {
 	kept = oldKept
 
 	// If the user chose to keep a corrupted/modified file, let them know
+	mainOutcome = outcomeSuccess
 	if kept {
 		mainStatus = "The input file was modified. Please be careful"
 		mainStatusColor = YELLOW
+		mainOutcome = outcomeCorrupted
+	} else if signer != nil {
+		if name, ok := signerName(signer); ok {
+			mainStatus = "Completed, signed by " + name
+			mainStatusColor = GREEN
+		} else {
+			mainStatus = "Completed, signed by unknown key " + fingerprint(signer)
+			mainStatusColor = YELLOW
+		}
 	} else {
 		mainStatus = "Completed"
 		mainStatusColor = GREEN
 	}
 }
 
-// If the OS denies reading or writing to a file
-func accessDenied(s string) {
-	mainStatus = s + " access denied by operating system"
-	mainStatusColor = RED
+// The options of a volume being encrypted, given explicitly so that a hidden volume can
+// be encrypted with options of its own
+type volumeOptions struct {
+	password       string
+	keyfiles       []string
+	keyfileOrdered bool
+	shares         bool // Use a random keyfile key that is split into shares
+	comments       string
+	paranoid       bool
+	reedsolo       bool
+	backupHeader   bool
+	padding        bool
+	signingKey     ed25519.PrivateKey // Sign the volume if set
+	recipients     []*pem.Block
+	batched        bool // Derive the key from the one shared by a batch of volumes
 }
 
-// If there isn't enough disk space
-func insufficientSpace(fin *os.File, fout *os.File) {
-	fin.Close()
-	fout.Close()
-	mainStatus = "Insufficient disk space"
-	mainStatusColor = RED
+// The options selected in the UI
+func currentOptions() volumeOptions {
+	options := volumeOptions{
+		password:       password,
+		keyfiles:       keyfiles,
+		keyfileOrdered: keyfileOrdered,
+		shares:         shares,
+		comments:       comments,
+		paranoid:       paranoid,
+		reedsolo:       reedsolo,
+		backupHeader:   backupHeader,
+		padding:        padding,
+		recipients:     recipients,
+		batched:        batchKeys != nil && len(recipients) == 0,
+	}
+	if sign {
+		options.signingKey = signingKey
+	}
+	return options
 }
 
-// If corruption is detected during decryption
-func broken(fin *os.File, fout *os.File, message string, keepOutput bool) {
-	fin.Close()
-	fout.Close()
-	mainStatus = message
+// A failure of encryptVolume that isn't caused by writing the output
+type statusError string
+
+func (e statusError) Error() string {
+	return string(e)
+}
+
+var errCancelled = errors.New("cancelled")
+
+// Encrypt 'total' bytes from 'source' into a volume written to 'fout', which goes through
+// the deniability layer 'deny' if there is one. Returns the keyfile key to make shares of.
+func encryptVolume(source io.Reader, total int64, fout outputWriter, deny *deniableWriter, o volumeOptions) ([]byte, error) {
+	popupStatus = "Generating values..."
+	giu.Update()
+
+	// Stores any errors when writing to file
+	errs := make([]error, 13)
+
+	// Set up cryptographic values
+	salt := make([]byte, 16)
+	hkdfSalt := make([]byte, 32)
+	serpentIV := make([]byte, 16)
+	nonce := make([]byte, 24)
+
+	// Write the program version to file
+	_, errs[0] = fout.Write(rsEncode(rs5, []byte(version)))
+
+	if len(o.comments) > 99999 {
+		panic(errors.New("comments exceed maximum length"))
+	}
+
+	// Encode and write the comment length to file
+	commentsLength := []byte(fmt.Sprintf("%05d", len(o.comments)))
+	_, errs[1] = fout.Write(rsEncode(rs5, commentsLength))
+
+	// Encode the comment and write to file
+	for _, i := range []byte(o.comments) {
+		_, err := fout.Write(rsEncode(rs1, []byte{i}))
+		if err != nil {
+			errs[2] = err
+		}
+	}
+
+	// Pad the payload to hide its exact size
+	var padLen int64
+	if o.padding {
+		padLen = paddingFor(total)
+		total += padLen + 8
+	}
+
+	// Configure flags and write to file
+	flags := make([]byte, 5)
+	if o.paranoid { // Paranoid mode selected
+		flags[0] = 1
+	}
+	if o.backupHeader { // Backup header at the end of the volume
+		flags[0] |= 2
+	}
+	if o.padding { // Size-hiding padding at the end of the payload
+		flags[0] |= 4
+	}
+	if o.signingKey != nil { // Signed by the sender
+		flags[0] |= 8
+	}
+	if len(o.recipients) > 0 { // Encrypted for recipients
+		flags[0] |= 16
+	}
+	if o.batched { // Key derived from one shared by a batch of volumes
+		flags[0] |= 32
+	}
+	if len(o.keyfiles) > 0 { // Keyfiles are being used
+		flags[1] = 1
+	}
+	if o.shares { // Keyfiles are generated as shares
+		flags[1] = 2
+	}
+	if o.keyfileOrdered { // Order of keyfiles matter
+		flags[2] = 1
+	}
+	flags[3] = 2    // Every block has a MAC of its own, so it can be read on its own
+	if o.reedsolo { // Full Reed-Solomon encoding is selected
+		flags[3] |= 1
+	}
+	if total%int64(MiB) >= int64(MiB)-128 { // Reed-Solomon internals
+		flags[4] = 1
+	}
+	_, errs[3] = fout.Write(rsEncode(rs5, flags))
+
+	// Fill values with Go's CSPRNG
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(hkdfSalt); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(serpentIV); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	if bytes.Equal(salt, make([]byte, 16)) {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+	if o.batched { // Every volume of the batch uses the first one's Argon2 salt
+		if batchSalt == nil {
+			batchSalt = salt
+		}
+		salt = batchSalt
+	}
+	if bytes.Equal(hkdfSalt, make([]byte, 32)) {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+	if bytes.Equal(serpentIV, make([]byte, 16)) {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+	if bytes.Equal(nonce, make([]byte, 24)) {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+
+	// Encode values with Reed-Solomon and write to file
+	_, errs[4] = fout.Write(rsEncode(rs16, salt))
+	_, errs[5] = fout.Write(rsEncode(rs32, hkdfSalt))
+	_, errs[6] = fout.Write(rsEncode(rs16, serpentIV))
+	_, errs[7] = fout.Write(rsEncode(rs24, nonce))
+
+	// Keep the header values to be signed at the end
+	signedHeader := bytes.Join([][]byte{
+		[]byte(version), commentsLength, []byte(o.comments), flags, salt, hkdfSalt, serpentIV, nonce,
+	}, nil)
+
+	// Write placeholders for future use
+	_, errs[8] = fout.Write(make([]byte, 192))  // Hash of encryption key
+	_, errs[9] = fout.Write(make([]byte, 96))   // Hash of keyfile key
+	_, errs[10] = fout.Write(make([]byte, 192)) // BLAKE2b/HMAC-SHA3 tag
+	if o.signingKey != nil {
+		_, errs[11] = fout.Write(make([]byte, 288)) // Public key and signature
+	}
+
+	// Use a random key wrapped for each recipient instead of deriving one from the password
+	var key []byte
+	if len(o.recipients) > 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		count := []byte(fmt.Sprintf("%05d", len(o.recipients)))
+		_, errs[12] = fout.Write(rsEncode(rs5, count))
+		signedHeader = append(signedHeader, count...)
+		for _, i := range o.recipients {
+			stanza := wrapKey(key, i.Bytes)
+			for j := 0; j < stanzaSize; j += 32 {
+				if _, err := fout.Write(rsEncode(rs32, stanza[j:j+32])); err != nil {
+					errs[12] = err
+				}
+			}
+			signedHeader = append(signedHeader, stanza...)
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Derive encryption keys and subkeys
+	if key == nil {
+		popupStatus = "Deriving key..."
+		giu.Update()
+
+		key = deriveKey(o.password, salt, o.paranoid)
+		if o.batched {
+			key = batchSubkey(key, hkdfSalt)
+		}
+	}
+
+	// If keyfiles are being used
+	var keyfileKey []byte
+	keyfileHash := make([]byte, 32)
+	if len(o.keyfiles) > 0 || o.shares {
+		popupStatus = "Reading keyfiles..."
+		giu.Update()
+
+		if o.shares {
+			// A random key that will be split into shares at the end
+			keyfileKey = make([]byte, 32)
+			if _, err := rand.Read(keyfileKey); err != nil {
+				panic(err)
+			}
+		} else {
+			var err error
+			if keyfileKey, err = readKeyfiles(o.keyfiles, o.keyfileOrdered); err != nil {
+				return nil, statusError("Unable to combine shares (" + err.Error() + ")")
+			}
+		}
+
+		// Store a hash of 'keyfileKey' for comparison
+		tmp := sha3.Sum256(keyfileKey)
+		keyfileHash = tmp[:]
+	}
+
+	// The outer layer of a deniable volume has keys of its own
+	if deny != nil {
+		popupStatus = "Deriving key..."
+		giu.Update()
+		deny.deriveKeys(o.password, keyfileKey, o.paranoid)
+	}
+
+	popupStatus = "Calculating values..."
+	giu.Update()
+
+	// Hash the encryption key for comparison when decrypting
+	keyHash := sha3.Sum512(key)
+
+	if keyfileKey != nil {
+		// Prevent an even number of duplicate keyfiles
+		if bytes.Equal(keyfileKey, make([]byte, 32)) {
+			return nil, statusError("Duplicate keyfiles detected")
+		}
+
+		// XOR the encryption key with the keyfile key
+		key = xorKeys(key, keyfileKey)
+	}
+
+	// Start the main encryption process
+	c := newVolumeCipher(key, nonce, serpentIV, hkdfSalt, o.paranoid, true, o.padding)
+	canCancel = true
+	startTime := time.Now()
+	done := 0
+	if o.padding {
+		source = &paddingReader{r: source, tail: padLen + 8, padLen: padLen}
+	}
+
+	// Read a byte ahead so the last block is known to be the last when its MAC is computed
+	reader := bufio.NewReaderSize(source, MiB)
+	for index := int64(0); ; index++ {
+		if !working {
+			return nil, errCancelled
+		}
+
+		// Read in a whole block from the file, since blocks are found by their position
+		src := make([]byte, MiB)
+		size, err := io.ReadFull(reader, src)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		_, err = reader.Peek(1)
+		last := err != nil
+		src = src[:size]
+		dst := make([]byte, len(src))
+
+		// Do the actual encryption
+		if o.paranoid {
+			c.serpent.XORKeyStream(dst, src)
+			copy(src, dst)
+		}
+
+		c.chacha.XORKeyStream(dst, src)
+		if _, err := c.mac.Write(dst); err != nil {
+			panic(err)
+		}
+		tag := c.blockMAC(index, last, dst)
+
+		if o.reedsolo {
+			copy(src, dst)
+			dst = nil
+			// If a full MiB is available
+			if len(src) == MiB {
+				// Encode every chunk
+				for i := 0; i < MiB; i += 128 {
+					dst = append(dst, rsEncode(rs128, src[i:i+128])...)
+				}
+			} else {
+				// Encode the full chunks
+				chunks := math.Floor(float64(len(src)) / 128)
+				for i := 0; float64(i) < chunks; i++ {
+					dst = append(dst, rsEncode(rs128, src[i*128:(i+1)*128])...)
+				}
+
+				// Pad and encode the final partial chunk
+				dst = append(dst, rsEncode(rs128, pad(src[int(chunks*128):]))...)
+			}
+
+			// Append the block's MAC so damaged blocks can be found without decoding everything
+			dst = append(dst, rsEncode(rs16, tag)...)
+		} else {
+			dst = append(dst, tag...)
+		}
+
+		// Write the data to output file
+		if _, err := fout.Write(dst); err != nil {
+			return nil, err
+		}
+
+		// Update stats
+		done += MiB
+		progress, speed, eta = statify(int64(done), total, startTime)
+		progressInfo = fmt.Sprintf("%.2f%%", progress*100)
+		popupStatus = fmt.Sprintf("Encrypting at %.2f MiB/s (ETA: %s)", speed, eta)
+		giu.Update()
+		c.advance()
+	}
+
+	progress = 0
+	progressInfo = ""
+	popupStatus = "Writing values..."
+	giu.Update()
+
+	// Authenticate the padding flag so it can't be flipped to truncate the output
+	if o.padding {
+		if _, err := c.mac.Write([]byte("padded")); err != nil {
+			panic(err)
+		}
+	}
+
+	// Seek back to header and write important values
+	if _, err := fout.Seek(int64(309+len(o.comments)*3), 0); err != nil {
+		panic(err)
+	}
+	if _, err := fout.Write(rsEncode(rs64, keyHash[:])); err != nil {
+		panic(err)
+	}
+	if _, err := fout.Write(rsEncode(rs32, keyfileHash)); err != nil {
+		panic(err)
+	}
+	authTag := c.mac.Sum(nil)
+	if _, err := fout.Write(rsEncode(rs64, authTag)); err != nil {
+		panic(err)
+	}
+	headerSize := 789 + len(o.comments)*3 + len(o.recipients)*stanzaSize*3
+	if len(o.recipients) > 0 {
+		headerSize += 15
+	}
+
+	// Sign the header values and authentication tag
+	if o.signingKey != nil {
+		signature := ed25519.Sign(o.signingKey, signedMessage(signedHeader, keyHash[:], keyfileHash, authTag))
+		if _, err := fout.Write(rsEncode(rs32, o.signingKey.Public().(ed25519.PublicKey))); err != nil {
+			panic(err)
+		}
+		if _, err := fout.Write(rsEncode(rs64, signature)); err != nil {
+			panic(err)
+		}
+		headerSize += 288
+	}
+
+	// Append a copy of the finished header to the end of the volume
+	if o.backupHeader {
+		header := make([]byte, headerSize)
+		if _, err := fout.ReadAt(header, 0); err != nil {
+			panic(err)
+		}
+		if _, err := fout.Seek(0, 2); err != nil {
+			panic(err)
+		}
+		trailer := []byte(fmt.Sprintf("backup%010d", len(header)))
+		if _, err := fout.Write(append(header, rsEncode(rs16, trailer)...)); err != nil {
+			return nil, err
+		}
+	}
+	return keyfileKey, nil
+}
+
+// Report why encryptVolume failed
+func encryptFailed(err error, fin io.Closer, fout io.Closer) {
+	if status, ok := err.(statusError); ok {
+		fin.Close()
+		fout.Close()
+		mainStatus = string(status)
+		mainStatusColor = RED
+	} else if err == errCancelled {
+		cancel(fin, fout)
+	} else {
+		insufficientSpace(fin, fout)
+	}
+}
+
+// Encrypt the hidden file into its own deniability layer at the end of the deniable volume
+func encryptHidden() bool {
+	fin, err := os.Open(hiddenFile)
+	if err != nil {
+		accessDenied("Read")
+		return false
+	}
+	stat, err := fin.Stat()
+	if err != nil {
+		fin.Close()
+		accessDenied("Read")
+		return false
+	}
+	output, err := os.OpenFile(outputFile+".incomplete", os.O_RDWR, 0)
+	if err != nil {
+		fin.Close()
+		accessDenied("Write")
+		return false
+	}
+
+	// The hidden file has its own password, and is already hidden among the random padding
+	options := currentOptions()
+	options.password = hiddenPassword
+	options.comments = ""
+	options.padding = false
+	deny := newDeniableWriter(output, true)
+	if _, err := encryptVolume(fin, stat.Size(), deny, deny, options); err != nil {
+		encryptFailed(err, fin, deny)
+		return false
+	}
+	if err := deny.finish(); err != nil {
+		insufficientSpace(fin, deny)
+		return false
+	}
+	if err := fin.Close(); err != nil {
+		panic(err)
+	}
+	if err := deny.Close(); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+// If the OS denies reading or writing to a file
+func accessDenied(s string) {
+	mainStatus = s + " access denied by operating system"
+	mainStatusColor = RED
+}
+
+// The size of each chunk when splitting a volume of 'size' bytes
+func splitChunkSize(size int64) int64 {
+	chunkSize, err := strconv.Atoi(splitSize)
+	if err != nil {
+		panic(err)
+	}
+	if splitSelected == 0 {
+		return int64(chunkSize) * KiB
+	} else if splitSelected == 1 {
+		return int64(chunkSize) * MiB
+	} else if splitSelected == 2 {
+		return int64(chunkSize) * GiB
+	} else if splitSelected == 3 {
+		return int64(chunkSize) * TiB
+	}
+	return int64(math.Ceil(float64(size) / float64(chunkSize)))
+}
+
+// If there isn't enough disk space
+func insufficientSpace(fin io.Closer, fout io.Closer) {
+	if fin != nil {
+		fin.Close()
+	}
+	if fout != nil {
+		fout.Close()
+	}
+	mainStatus = "Insufficient disk space"
+	mainStatusColor = RED
+	if upload, ok := fout.(remoteWriter); ok && upload.failed() != nil {
+		mainStatus = "Upload failed (" + upload.failed().Error() + ")"
+	}
+}
+
+// If corruption is detected during decryption
+func broken(fin io.Closer, fout io.Closer, message string, keepOutput bool) {
+	if fin != nil {
+		fin.Close()
+	}
+	if fout != nil {
+		fout.Close()
+	}
+	mainStatus = message
 	mainStatusColor = RED
+	mainOutcome = outcomeCorrupted
 
 	// Clean up files since decryption failed
 	if recombine {
@@ -2620,20 +4060,27 @@ func broken(fin *os.File, fout *os.File, message string, keepOutput bool) {
 	}
 	if !keepOutput {
 		os.Remove(outputFile)
+		os.Remove(outputFile + ".incomplete")
 	}
 }
 
 // Stop working if user hits "Cancel"
-func cancel(fin *os.File, fout *os.File) {
-	fin.Close()
-	fout.Close()
+func cancel(fin io.Closer, fout io.Closer) {
+	if fin != nil {
+		fin.Close()
+	}
+	if fout != nil {
+		fout.Close()
+	}
 	mainStatus = "Operation cancelled by user"
 	mainStatusColor = WHITE
 }
 
 // Reset the UI to a clean state with nothing selected or checked
 func resetUI() {
-	imgui.ClearActiveID()
+	if window != nil { // There is no UI when running from the command line
+		imgui.ClearActiveID()
+	}
 	mode = ""
 
 	inputFile = ""
@@ -2642,6 +4089,7 @@ func resetUI() {
 	onlyFiles = nil
 	onlyFolders = nil
 	allFiles = nil
+	deletedFiles = nil
 	inputLabel = "Drop files and folders into this window"
 
 	password = ""
@@ -2660,6 +4108,19 @@ func resetUI() {
 	keyfiles = nil
 	keyfileOrdered = false
 	keyfileLabel = "None selected"
+	shares = false
+	sharesNeeded = 2
+	sharesTotal = 3
+	keyfileSetName = ""
+
+	recipient = false
+	recipients = nil
+	recipientLabel = "None selected"
+
+	hiddenVolume = false
+	hiddenFile = ""
+	hiddenPassword = ""
+	hiddenCPassword = ""
 
 	comments = ""
 	commentsLabel = "Comments:"
@@ -2667,11 +4128,19 @@ func resetUI() {
 
 	paranoid = false
 	reedsolo = false
+	backupHeader = false
 	deniability = false
 	recursively = false
+	outputRoot = ""
+	randomNames = false
+	profileName = ""
 	split = false
 	splitSize = ""
 	splitSelected = 1
+	padding = false
+	padSize = ""
+	padSelected = 1
+	sign = false
 	recombine = false
 	compress = false
 	delete = false
@@ -2683,6 +4152,7 @@ func resetUI() {
 	startLabel = "Start"
 	mainStatus = "Ready"
 	mainStatusColor = WHITE
+	mainOutcome = outcomeFailed
 	popupStatus = ""
 	requiredFreeSpace = 0
 
@@ -2702,11 +4172,6 @@ func rsEncode(rs *infectious.FEC, data []byte) []byte {
 
 // Reed-Solomon decoder
 func rsDecode(rs *infectious.FEC, data []byte) ([]byte, error) {
-	// If fast decode, just return the first 128 bytes
-	if rs.Total() == 136 && fastDecode {
-		return data[:128], nil
-	}
-
 	tmp := make([]infectious.Share, rs.Total())
 	for i := range rs.Total() {
 		tmp[i].Number = i
@@ -2726,6 +4191,32 @@ func rsDecode(rs *infectious.FEC, data []byte) ([]byte, error) {
 	return res, nil
 }
 
+// Decode a block of 128+8 codewords, unpadding the final codeword if it is padded
+func rsDecodeBlock(data []byte, padded bool, fast bool) ([]byte, error) {
+	if len(data) == 0 || len(data)%136 != 0 {
+		return nil, errors.New("block is not a whole number of codewords")
+	}
+	var res []byte
+	var err error
+	for i := 0; i < len(data); i += 136 {
+		// If fast decode, just take the first 128 bytes
+		tmp := data[i : i+128]
+		if !fast {
+			var decodeErr error
+			if tmp, decodeErr = rsDecode(rs128, data[i:i+136]); decodeErr != nil {
+				err = decodeErr
+			}
+		}
+
+		// The final codeword of a partial block is always padded
+		if i+136 == len(data) && (len(data) != MiB/128*136 || padded) {
+			tmp = unpad(tmp)
+		}
+		res = append(res, tmp...)
+	}
+	return res, err
+}
+
 // PKCS#7 pad (for use with Reed-Solomon)
 func pad(data []byte) []byte {
 	padLen := 128 - len(data)%128
@@ -2736,170 +4227,6633 @@ func pad(data []byte) []byte {
 // PKCS#7 unpad
 func unpad(data []byte) []byte {
 	padLen := int(data[127])
+	if padLen > 128 { // Damaged padding, leave it to the MAC
+		return data
+	}
 	return data[:128-padLen]
 }
 
-// Generate a cryptographically secure password
-func genPassword() string {
-	chars := ""
-	if passgenUpper {
-		chars += "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	}
-	if passgenLower {
-		chars += "abcdefghijklmnopqrstuvwxyz"
-	}
-	if passgenNums {
-		chars += "1234567890"
-	}
-	if passgenSymbols {
-		chars += "-=_+!@#$^&()?<>"
-	}
-	tmp := make([]byte, passgenLength)
-	for i := range int(passgenLength) {
-		j, _ := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
-		tmp[i] = chars[j.Int64()]
-	}
-	if passgenCopy {
-		giu.Context.GetPlatform().SetClipboard(string(tmp))
+// Hash keyfiles into a 32-byte key, either progressively if order matters or individually
+func hashKeyfiles(paths []string, ordered bool) []byte {
+	var keyfileKey []byte
+	var keyfileTotal int64
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			panic(err) // we already checked os.Stat in onDrop
+		}
+		keyfileTotal += stat.Size()
 	}
-	return string(tmp)
-}
 
-// Convert done, total, and starting time to progress, speed, and ETA
-func statify(done int64, total int64, start time.Time) (float32, float64, string) {
-	progress := float32(done) / float32(total)
-	elapsed := float64(time.Since(start)) / float64(MiB) / 1000
-	speed := float64(done) / elapsed / float64(MiB)
-	eta := int(math.Floor(float64(total-done) / (speed * float64(MiB))))
+	if ordered { // If order matters, hash progressively
+		// Large keyfiles hashed earlier in this session don't need to be read again
+		cacheKey := keyfileCacheKey(paths)
+		if sum, ok := keyfileCache[cacheKey]; ok {
+			return append([]byte{}, sum...)
+		}
+
+		var tmp = sha3.New256()
+		var keyfileDone int
+
+		// For each keyfile...
+		for _, path := range paths {
+			fin, err := os.Open(path)
+			if err != nil {
+				panic(err)
+			}
+			for { // Read in chunks of 1 MiB
+				data := make([]byte, MiB)
+				size, err := fin.Read(data)
+				if err != nil {
+					break
+				}
+				data = data[:size]
+				if _, err := tmp.Write(data); err != nil { // Hash the data
+					panic(err)
+				}
+
+				// Update progress
+				keyfileDone += size
+				progress = float32(keyfileDone) / float32(keyfileTotal)
+				giu.Update()
+			}
+			if err := fin.Close(); err != nil {
+				panic(err)
+			}
+		}
+		keyfileKey = tmp.Sum(nil) // Get the SHA3-256
+		if keyfileTotal >= keyfileCacheSize {
+			keyfileCache[cacheKey] = append([]byte{}, keyfileKey...)
+		}
+	} else { // If order doesn't matter, hash individually and combine
+		var keyfileDone int
+
+		// For each keyfile...
+		for _, path := range paths {
+			// Large keyfiles hashed earlier in this session don't need to be read again
+			cacheKey := keyfileCacheKey([]string{path})
+			sum, cached := keyfileCache[cacheKey]
+			if cached {
+				sum = append([]byte{}, sum...)
+			}
+
+			fin, err := os.Open(path)
+			if err != nil {
+				panic(err)
+			}
+			tmp := sha3.New256()
+			for !cached { // Read in chunks of 1 MiB
+				data := make([]byte, MiB)
+				size, err := fin.Read(data)
+				if err != nil {
+					break
+				}
+				data = data[:size]
+				if _, err := tmp.Write(data); err != nil { // Hash the data
+					panic(err)
+				}
+
+				// Update progress
+				keyfileDone += size
+				progress = float32(keyfileDone) / float32(keyfileTotal)
+				giu.Update()
+			}
+			if err := fin.Close(); err != nil {
+				panic(err)
+			}
+
+			if !cached {
+				sum = tmp.Sum(nil) // Get the SHA3-256
+				if stat, err := os.Stat(path); err == nil && stat.Size() >= keyfileCacheSize {
+					keyfileCache[cacheKey] = append([]byte{}, sum...)
+				}
+			}
+
+			// XOR keyfile hash with 'keyfileKey'
+			if keyfileKey == nil {
+				keyfileKey = sum
+			} else {
+				for i, j := range sum {
+					keyfileKey[i] ^= j
+				}
+			}
+		}
+	}
+	return keyfileKey
+}
+
+// Identify keyfiles by their paths, sizes, and modification times, so changed keyfiles are hashed again
+func keyfileCacheKey(paths []string) string {
+	var key []string
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			panic(err) // we already checked os.Stat in onDrop
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		key = append(key, fmt.Sprintf("%s\x00%d\x00%d", abs, stat.Size(), stat.ModTime().UnixNano()))
+	}
+	return strings.Join(key, "\x00")
+}
+
+// Replace directories with the files inside them, in a fixed order so ordered keyfiles stay usable
+func expandKeyfiles(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil || !stat.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+		// Walk visits the files of each directory in lexical order
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				expanded = append(expanded, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+// A named list of keyfiles, saved in keyfiles.json
+type keyfileSet struct {
+	Name     string   `json:"name"`
+	Keyfiles []string `json:"keyfiles"` // Absolute paths, in order
+	Ordered  bool     `json:"ordered"`
+}
+
+// Load all named keyfile sets
+func keyfileSets() []keyfileSet {
+	if sets, ok := cachedConfig("keyfiles.json").([]keyfileSet); ok {
+		return sets
+	}
+	var saved, sets []keyfileSet
+	loadConfig("keyfiles.json", &saved)
+	for _, i := range saved {
+		if i.Name != "" && len(i.Keyfiles) > 0 {
+			sets = append(sets, i)
+		}
+	}
+	cacheConfig("keyfiles.json", sets)
+	return sets
+}
+
+// Save 'paths' as a keyfile set called 'name', replacing any set with that name
+func saveKeyfileSet(name string, paths []string, ordered bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("invalid name")
+	}
+	if len(paths) == 0 {
+		return errors.New("no keyfiles")
+	}
+	var abs []string
+	for _, i := range paths {
+		path, err := filepath.Abs(i)
+		if err != nil {
+			return err
+		}
+		abs = append(abs, path)
+	}
+	sets := []keyfileSet{}
+	for _, i := range keyfileSets() {
+		if i.Name != name {
+			sets = append(sets, i)
+		}
+	}
+	return saveConfig("keyfiles.json", append(sets, keyfileSet{Name: name, Keyfiles: abs, Ordered: ordered}))
+}
+
+// Remove the keyfile set called 'name'
+func removeKeyfileSet(name string) error {
+	sets := []keyfileSet{}
+	for _, i := range keyfileSets() {
+		if i.Name != name {
+			sets = append(sets, i)
+		}
+	}
+	return saveConfig("keyfiles.json", sets)
+}
+
+// Look up the keyfiles and ordering of the keyfile set called 'name'
+func loadKeyfileSet(name string) ([]string, bool, error) {
+	for _, i := range keyfileSets() {
+		if i.Name != name {
+			continue
+		}
+		for _, path := range i.Keyfiles {
+			if _, err := os.Stat(path); err != nil {
+				return nil, false, errors.New("missing " + filepath.Base(path))
+			}
+		}
+		return i.Keyfiles, i.Ordered, nil
+	}
+	return nil, false, errors.New("no set named " + name)
+}
+
+// The profile that dropped files start from, once it's been saved
+const defaultProfile = "Default"
+
+// A named set of options, saved in profiles.json
+type profile struct {
+	Name            string   `json:"name"`
+	Options         []string `json:"options,omitempty"` // "paranoid", "reed-solomon", "backup-header", "compress"
+	Split           string   `json:"split,omitempty"`   // An amount like "4 GiB"
+	Pad             string   `json:"pad,omitempty"`     // An amount like "16 MiB", "2^n", or "10 %"
+	PasswordLength  int      `json:"passwordLength,omitempty"`
+	PasswordOptions []string `json:"passwordOptions,omitempty"` // "upper", "lower", "numbers", "symbols", "copy"
+}
+
+// Load all saved profiles of options
+func profiles() []profile {
+	if list, ok := cachedConfig("profiles.json").([]profile); ok {
+		return list
+	}
+	var saved, list []profile
+	loadConfig("profiles.json", &saved)
+	for _, i := range saved {
+		if i.Name != "" {
+			list = append(list, i)
+		}
+	}
+	cacheConfig("profiles.json", list)
+	return list
+}
+
+// Look up the profile called 'name'
+func findProfile(name string) *profile {
+	for _, i := range profiles() {
+		if i.Name == name {
+			return &i
+		}
+	}
+	return nil
+}
+
+// Save the current options as the profile called 'name', replacing any profile with that name
+func saveProfile(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("invalid name")
+	}
+	saved := profile{Name: name, PasswordLength: int(passgenLength)}
+	for _, i := range []struct {
+		name string
+		on   bool
+	}{{"paranoid", paranoid}, {"reed-solomon", reedsolo}, {"backup-header", backupHeader}, {"compress", compress}} {
+		if i.on {
+			saved.Options = append(saved.Options, i.name)
+		}
+	}
+	saved.Split = formatAmount(split, splitSize, splitUnits, splitSelected)
+	saved.Pad = formatAmount(padding, padSize, padUnits, padSelected)
+	for _, i := range []struct {
+		name string
+		on   bool
+	}{{"upper", passgenUpper}, {"lower", passgenLower}, {"numbers", passgenNums}, {"symbols", passgenSymbols}, {"copy", passgenCopy}} {
+		if i.on {
+			saved.PasswordOptions = append(saved.PasswordOptions, i.name)
+		}
+	}
+
+	list := []profile{}
+	for _, i := range profiles() {
+		if i.Name != name {
+			list = append(list, i)
+		}
+	}
+	return saveConfig("profiles.json", append(list, saved))
+}
+
+// Remove the profile called 'name'
+func removeProfile(name string) error {
+	list := []profile{}
+	for _, i := range profiles() {
+		if i.Name != name {
+			list = append(list, i)
+		}
+	}
+	return saveConfig("profiles.json", list)
+}
+
+// Set the options saved in 'profile'
+func applyProfile(profile *profile) {
+	options := make(map[string]bool)
+	for _, i := range profile.Options {
+		options[i] = true
+	}
+	paranoid = options["paranoid"]
+	reedsolo = options["reed-solomon"]
+	backupHeader = options["backup-header"]
+	compress = options["compress"] && !recursively && (len(allFiles) > 1 || len(onlyFolders) > 0)
+	split, splitSize, splitSelected = parseAmount(profile.Split, splitUnits)
+	padding, padSize, padSelected = parseAmount(profile.Pad, padUnits)
+
+	if profile.PasswordLength == 0 {
+		return
+	}
+	if profile.PasswordLength >= 12 && profile.PasswordLength <= 64 {
+		passgenLength = int32(profile.PasswordLength)
+	}
+	options = make(map[string]bool)
+	for _, i := range profile.PasswordOptions {
+		options[i] = true
+	}
+	passgenUpper = options["upper"]
+	passgenLower = options["lower"]
+	passgenNums = options["numbers"]
+	passgenSymbols = options["symbols"]
+	passgenCopy = options["copy"]
+}
+
+// Write an option's size and unit as an amount like "4 GiB", or "" if it's off
+func formatAmount(on bool, size string, units []string, selected int32) string {
+	if on && units[selected] == "2^n" {
+		return units[selected]
+	}
+	if !on || size == "" {
+		return ""
+	}
+	return size + " " + units[selected]
+}
+
+// Parse an amount like "4 GiB" into whether it's enabled, its size, and its unit
+func parseAmount(amount string, units []string) (bool, string, int32) {
+	fields := strings.Fields(amount)
+	size, unit := "", ""
+	if len(fields) == 1 {
+		unit = fields[0]
+	} else if len(fields) == 2 {
+		size, unit = fields[0], fields[1]
+		if n, err := strconv.Atoi(size); err != nil || n <= 0 {
+			return false, "", 1
+		}
+	}
+	for i, j := range units {
+		if j == unit && (size != "" || j == "2^n") {
+			return true, size, int32(i)
+		}
+	}
+	return false, "", 1
+}
+
+// Describe the options of a profile in a few words
+func profileSummary(profile *profile) string {
+	summary := append([]string{}, profile.Options...)
+	if profile.Split != "" {
+		summary = append(summary, "split "+profile.Split)
+	}
+	if profile.Pad != "" {
+		summary = append(summary, "pad "+profile.Pad)
+	}
+	if len(summary) == 0 {
+		return "no options"
+	}
+	return strings.Join(summary, ", ")
+}
+
+// Derive a volume's key from the key shared by its batch, using the volume's own HKDF salt
+func batchSubkey(key []byte, hkdfSalt []byte) []byte {
+	subkey := make([]byte, 32)
+	tmp := hkdf.New(sha3.New256, key, hkdfSalt, []byte("batch"))
+	if n, err := tmp.Read(subkey); err != nil || n != 32 {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	return subkey
+}
+
+// Derive a key from the password with Argon2, at the strength of volumes
+func passwordKey(password string, salt []byte, paranoid bool) []byte {
+	var key []byte
+	if paranoid {
+		key = argon2.IDKey(
+			[]byte(password),
+			salt,
+			8,     // 8 passes
+			1<<20, // 1 GiB memory
+			8,     // 8 threads
+			32,    // 32-byte output key
+		)
+	} else {
+		key = argon2.IDKey(
+			[]byte(password),
+			salt,
+			4,
+			1<<20,
+			4,
+			32,
+		)
+	}
+	if bytes.Equal(key, make([]byte, 32)) {
+		panic(errors.New("fatal crypto/argon2 error"))
+	}
+	return key
+}
+
+// Derive a key from the password with Argon2, only once for each salt during a batch
+func deriveKey(password string, salt []byte, paranoid bool) []byte {
+	batchId := fmt.Sprintf("%x %t %x", salt, paranoid, sha3.Sum256([]byte(password)))
+	key := batchKeys[batchId]
+	if key == nil {
+		key = passwordKey(password, salt, paranoid)
+	}
+	if batchKeys != nil {
+		batchKeys[batchId] = key
+	}
+	return key
+}
+
+// Wipe the keys of a batch once it's done
+func endBatch() {
+	for _, key := range batchKeys {
+		clear(key)
+	}
+	batchKeys = nil
+	batchSalt = nil
+	batchDenySalt = nil
+}
+
+// XOR a key with the keyfile key
+func xorKeys(key []byte, keyfileKey []byte) []byte {
+	tmp := make([]byte, 32)
+	for i := range tmp {
+		tmp[i] = key[i] ^ keyfileKey[i]
+	}
+	return tmp
+}
+
+// The ciphers and MACs of a volume's payload, with subkeys derived from its key
+type volumeCipher struct {
+	key      []byte
+	hkdf     io.Reader
+	chacha   *chacha20.Cipher
+	block    cipher.Block // Serpent
+	serpent  cipher.Stream
+	mac      hash.Hash
+	blockKey []byte // Key of the per-block MACs
+	padding  bool   // The payload ends in size-hiding padding
+	counter  int
+}
+
+func newVolumeCipher(key []byte, nonce []byte, serpentIV []byte, hkdfSalt []byte, paranoid bool, blockMACs bool, padding bool) *volumeCipher {
+	c := &volumeCipher{key: key, hkdf: hkdf.New(sha3.New256, key, hkdfSalt, nil), padding: padding}
+	var err error
+	c.chacha, err = chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+
+	// Use HKDF-SHA3 to generate a subkey for the MAC
+	subkey := make([]byte, 32)
+	if n, err := c.hkdf.Read(subkey); err != nil || n != 32 {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	if paranoid {
+		c.mac = hmac.New(sha3.New512, subkey) // HMAC-SHA3
+	} else {
+		c.mac, err = blake2b.New512(subkey) // Keyed BLAKE2b
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Generate another subkey for use as Serpent's key
+	serpentKey := make([]byte, 32)
+	if n, err := c.hkdf.Read(serpentKey); err != nil || n != 32 {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	c.block, err = serpent.NewCipher(serpentKey)
+	if err != nil {
+		panic(err)
+	}
+	c.serpent = cipher.NewCTR(c.block, serpentIV)
+
+	// And one more for the per-block MACs
+	c.blockKey = make([]byte, 32)
+	if blockMACs {
+		if n, err := c.hkdf.Read(c.blockKey); err != nil || n != 32 {
+			panic(errors.New("fatal hkdf.Read error"))
+		}
+	}
+	return c
+}
+
+// The MAC of the ciphertext of the block at 'index', stored after it. It covers the block's position
+// and whether it's the last one, so blocks can't be moved, dropped, or cut off at the end, and the
+// last one covers the padding flag too, since the whole volume's MAC isn't checked when mounting.
+func (c *volumeCipher) blockMAC(index int64, last bool, data []byte) []byte {
+	mac, err := blake2b.New(16, c.blockKey)
+	if err != nil {
+		panic(err)
+	}
+	position := make([]byte, 9)
+	binary.LittleEndian.PutUint64(position, uint64(index))
+	if last {
+		position[8] = 1
+		if c.padding {
+			position[8] = 2
+		}
+	}
+	if _, err := mac.Write(position); err != nil {
+		panic(err)
+	}
+	if _, err := mac.Write(data); err != nil {
+		panic(err)
+	}
+	return mac.Sum(nil)
+}
+
+// Count a MiB of the payload, and change the nonce/IV after 60 GiB to prevent overflow
+func (c *volumeCipher) advance() {
+	c.counter += MiB
+	if c.counter < 60*GiB {
+		return
+	}
+
+	// ChaCha20
+	nonce := make([]byte, 24)
+	if n, err := c.hkdf.Read(nonce); err != nil || n != 24 {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	var err error
+	c.chacha, err = chacha20.NewUnauthenticatedCipher(c.key, nonce)
+	if err != nil {
+		panic(err)
+	}
+
+	// Serpent
+	serpentIV := make([]byte, 16)
+	if n, err := c.hkdf.Read(serpentIV); err != nil || n != 16 {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	c.serpent = cipher.NewCTR(c.block, serpentIV)
+
+	// Reset counter to 0
+	c.counter = 0
+}
+
+// A volume with per-block MACs, decrypted a block at a time as it's read, from any offset. Each
+// block is checked against its MAC before it's decrypted, so nothing has to be decrypted up front.
+type volumeReader struct {
+	file     inputReader // The volume, or the layer of a deniable one
+	start    int64       // Where the payload starts in 'file'
+	length   int64       // Length of the payload, with its MACs and Reed-Solomon encoding
+	blocks   int64
+	size     int64 // Length of the plaintext, without the size-hiding padding
+	c        *volumeCipher
+	segments [][2][]byte // Nonce and Serpent IV of every 60 GiB, taken from HKDF as they're needed
+	paranoid bool
+	reedsolo bool
+	padded   bool // Reed-Solomon internals, as in flags[4]
+	lock     sync.Mutex
+	cached   map[int64][]byte // Blocks read since the cache was last emptied
+}
+
+const volumeReaderCache = 16
+
+func newVolumeReader(file inputReader, start int64, length int64, c *volumeCipher, authTag []byte, nonce []byte, serpentIV []byte, paranoid bool, reedsolo bool, padded bool) (*volumeReader, error) {
+	v := &volumeReader{
+		file:     file,
+		start:    start,
+		length:   length,
+		c:        c,
+		segments: [][2][]byte{{nonce, serpentIV}},
+		paranoid: paranoid,
+		reedsolo: reedsolo,
+		padded:   padded,
+		cached:   make(map[int64][]byte),
+	}
+	v.blocks = (length + v.stride() - 1) / v.stride()
+
+	// An empty payload has no blocks to check, so check the whole volume's MAC instead
+	if v.blocks == 0 {
+		if c.padding {
+			if _, err := c.mac.Write([]byte("padded")); err != nil {
+				panic(err)
+			}
+		}
+		if subtle.ConstantTimeCompare(c.mac.Sum(nil), authTag) == 0 {
+			return nil, errors.New("the volume is damaged or modified")
+		}
+		return v, nil
+	}
+
+	// Reading the last block checks that nothing was cut off at the end
+	last, err := v.block(v.blocks - 1)
+	if err != nil {
+		return nil, err
+	}
+	v.size = (v.blocks-1)*int64(MiB) + int64(len(last))
+
+	// Leave out the size-hiding padding, using the length at the very end
+	if c.padding {
+		tmp := make([]byte, 8)
+		if v.size < 8 {
+			return nil, errors.New("the padding is damaged")
+		} else if _, err := v.ReadAt(tmp, v.size-8); err != nil {
+			return nil, err
+		}
+		padLen := int64(binary.LittleEndian.Uint64(tmp))
+		if padLen < 0 || padLen > v.size-8 {
+			return nil, errors.New("the padding is damaged")
+		}
+		v.size -= 8 + padLen
+	}
+	return v, nil
+}
+
+// The length of a block in the volume, with its MAC
+func (v *volumeReader) stride() int64 {
+	if v.reedsolo {
+		return int64(MiB/128*136 + 48)
+	}
+	return int64(MiB + 16)
+}
+
+// Read, check, and decrypt the block at 'index'
+func (v *volumeReader) block(index int64) ([]byte, error) {
+	if data, ok := v.cached[index]; ok {
+		return data, nil
+	}
+	src := make([]byte, min(v.stride(), v.length-index*v.stride()))
+	if _, err := v.file.ReadAt(src, v.start+index*v.stride()); err != nil {
+		return nil, err
+	}
+	last := index == v.blocks-1
+
+	// Check the block against its MAC, repairing it first if it doesn't match
+	var data []byte
+	if v.reedsolo {
+		if len(src) <= 48 {
+			return nil, errors.New("the volume is damaged or modified")
+		}
+		tag, err := rsDecode(rs16, src[len(src)-48:])
+		if err != nil {
+			return nil, err
+		}
+		encoded := src[:len(src)-48]
+		data, err = rsDecodeBlock(encoded, last && v.padded, true)
+		if err != nil || !hmac.Equal(v.c.blockMAC(index, last, data), tag) {
+			data, err = rsDecodeBlock(encoded, last && v.padded, false)
+			if err != nil || !hmac.Equal(v.c.blockMAC(index, last, data), tag) {
+				return nil, errors.New("the volume is damaged or modified")
+			}
+		}
+	} else {
+		if len(src) <= 16 {
+			return nil, errors.New("the volume is damaged or modified")
+		}
+		data = src[:len(src)-16]
+		if !hmac.Equal(v.c.blockMAC(index, last, data), src[len(src)-16:]) {
+			return nil, errors.New("the volume is damaged or modified")
+		}
+	}
+
+	// Start the ciphers partway into the 60 GiB the block is in
+	segment, offset := index/(60*GiB/MiB), index%(60*GiB/MiB)*int64(MiB)
+	for int64(len(v.segments)) <= segment {
+		nonce, serpentIV := make([]byte, 24), make([]byte, 16)
+		if n, err := v.c.hkdf.Read(nonce); err != nil || n != 24 {
+			panic(errors.New("fatal hkdf.Read error"))
+		}
+		if n, err := v.c.hkdf.Read(serpentIV); err != nil || n != 16 {
+			panic(errors.New("fatal hkdf.Read error"))
+		}
+		v.segments = append(v.segments, [2][]byte{nonce, serpentIV})
+	}
+	chacha, err := chacha20.NewUnauthenticatedCipher(v.c.key, v.segments[segment][0])
+	if err != nil {
+		panic(err)
+	}
+	chacha.SetCounter(uint32(offset / 64))
+	dst := make([]byte, len(data))
+	chacha.XORKeyStream(dst, data)
+	if v.paranoid {
+		// Serpent-CTR counts with the whole IV, so add the number of 16-byte blocks before this one to it
+		counter := bytes.Clone(v.segments[segment][1])
+		carry := uint64(offset / 16)
+		for i := 15; i >= 0 && carry > 0; i-- {
+			carry += uint64(counter[i])
+			counter[i] = byte(carry)
+			carry >>= 8
+		}
+		cipher.NewCTR(v.c.block, counter).XORKeyStream(dst, dst)
+	}
+
+	if len(v.cached) >= volumeReaderCache {
+		clear(v.cached)
+	}
+	v.cached[index] = dst
+	return dst, nil
+}
+
+func (v *volumeReader) ReadAt(data []byte, off int64) (int, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	n := 0
+	for n < len(data) {
+		pos := off + int64(n)
+		if pos >= v.size {
+			return n, io.EOF
+		}
+		block, err := v.block(pos / int64(MiB))
+		if err != nil {
+			return n, err
+		}
+		end := len(data)
+		if int64(end-n) > v.size-pos {
+			end = n + int(v.size-pos)
+		}
+		n += copy(data[n:end], block[pos%int64(MiB):])
+	}
+	return n, nil
+}
+
+func (v *volumeReader) Close() error {
+	return v.file.Close()
+}
+
+// The message signed by the sender, covering the header values and authentication tag
+func signedMessage(header []byte, keyHash []byte, keyfileHash []byte, authTag []byte) []byte {
+	tmp := sha3.New512()
+	for _, i := range [][]byte{[]byte("picocrypt signature"), header, keyHash, keyfileHash, authTag} {
+		if _, err := tmp.Write(i); err != nil {
+			panic(err)
+		}
+	}
+	return tmp.Sum(nil)
+}
+
+// Short fingerprint of a public key for comparing by eye
+func fingerprint(pub []byte) string {
+	tmp := sha3.Sum256(pub)
+	return hex.EncodeToString(tmp[:8])
+}
+
+// Save a private key as PEM, along with its public key next to it as a .pub
+func saveKeypair(path string, name string, privateType string, private []byte, publicType string, public []byte) error {
+	headers := map[string]string{"Name": strings.Join(strings.Fields(name), " ")}
+	private = pem.EncodeToMemory(&pem.Block{Type: privateType, Headers: headers, Bytes: private})
+	public = pem.EncodeToMemory(&pem.Block{Type: publicType, Headers: headers, Bytes: public})
+	if err := os.WriteFile(path, private, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(strings.TrimSuffix(path, filepath.Ext(path))+".pub", public, 0644)
+}
+
+// Read a key saved by saveKeypair, making sure it's the right type and size
+func readKey(path string, keyType string, size int) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != keyType || len(block.Bytes) != size {
+		return nil, errors.New("not a " + strings.ToLower(strings.TrimPrefix(keyType, "PICOCRYPT ")))
+	}
+	return block, nil
+}
+
+// Generate a signing keypair
+func createSigningKey(path string, name string) (ed25519.PrivateKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return priv, saveKeypair(path, name, "PICOCRYPT SIGNING KEY", priv.Seed(), "PICOCRYPT PUBLIC KEY", pub)
+}
+
+// Read a signing key created by createSigningKey, along with its owner's name
+func readSigningKey(path string) (ed25519.PrivateKey, string, error) {
+	block, err := readKey(path, "PICOCRYPT SIGNING KEY", ed25519.SeedSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return ed25519.NewKeyFromSeed(block.Bytes), block.Headers["Name"], nil
+}
+
+// Read a public key created by createSigningKey
+func readPublicKey(path string) (*pem.Block, error) {
+	return readKey(path, "PICOCRYPT PUBLIC KEY", ed25519.PublicKeySize)
+}
+
+// Profiles and keyfile sets are kept as JSON files, and trusted signers as a PEM file, in the user's config directory
+func configPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "Picocrypt", name), nil
+}
+
+// Config files as they were last loaded, by path, so the window doesn't read them every frame
+// Saving a config file clears it, so it's loaded again the next time it's used
+var configCache = make(map[string]any)
+var configLock sync.Mutex
+
+// Get what was cached for the config file 'name', or nil if it hasn't been loaded since it was saved
+func cachedConfig(name string) any {
+	path, err := configPath(name)
+	if err != nil {
+		return nil
+	}
+	configLock.Lock()
+	defer configLock.Unlock()
+	return configCache[path]
+}
+
+// Keep 'v' as what was loaded from the config file 'name', or nil to load it again next time
+func cacheConfig(name string, v any) {
+	path, err := configPath(name)
+	if err != nil {
+		return
+	}
+	configLock.Lock()
+	defer configLock.Unlock()
+	configCache[path] = v
+}
+
+// Load the JSON config file 'name' into 'v', leaving it as it is if there's no file
+func loadConfig(name string, v any) error {
+	path, err := configPath(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Replace the contents of the JSON config file 'name' with 'v'
+func saveConfig(name string, v any) error {
+	path, err := configPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	defer cacheConfig(name, nil)
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Load the keys of type 'kind' from the PEM file 'name' in the config directory
+func loadKeys(name string, kind string) []*pem.Block {
+	path, err := configPath(name)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == kind {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// Replace the keys in the PEM file 'name' in the config directory
+func saveKeys(name string, blocks []*pem.Block) error {
+	path, err := configPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	var data []byte
+	for _, i := range blocks {
+		data = append(data, pem.EncodeToMemory(i)...)
+	}
+	defer cacheConfig(name, nil)
+	return os.WriteFile(path, data, 0600)
+}
+
+// Load the public keys of all trusted signers
+func trustedSigners() []*pem.Block {
+	if signers, ok := cachedConfig("signers.pem").([]*pem.Block); ok {
+		return signers
+	}
+	var signers []*pem.Block
+	for _, i := range loadKeys("signers.pem", "PICOCRYPT PUBLIC KEY") {
+		if len(i.Bytes) == ed25519.PublicKeySize {
+			signers = append(signers, i)
+		}
+	}
+	cacheConfig("signers.pem", signers)
+	return signers
+}
+
+// Add the public key at 'path' to the trusted signers
+func trustSigner(path string) (string, error) {
+	block, err := readPublicKey(path)
+	if err != nil {
+		return "", err
+	}
+	signers := trustedSigners()
+	for _, i := range signers {
+		if bytes.Equal(i.Bytes, block.Bytes) {
+			return i.Headers["Name"], nil
+		}
+	}
+	return block.Headers["Name"], saveKeys("signers.pem", append(signers, block))
+}
+
+// Remove a public key from the trusted signers
+func untrustSigner(pub []byte) error {
+	var signers []*pem.Block
+	for _, i := range trustedSigners() {
+		if !bytes.Equal(i.Bytes, pub) {
+			signers = append(signers, i)
+		}
+	}
+	return saveKeys("signers.pem", signers)
+}
+
+// Look up the name of a trusted signer
+func signerName(pub []byte) (string, bool) {
+	for _, i := range trustedSigners() {
+		if bytes.Equal(i.Bytes, pub) {
+			name := i.Headers["Name"]
+			if name == "" {
+				name = fingerprint(pub)
+			}
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// The volume key wrapped for a recipient: an ephemeral X25519 key, an ML-KEM-768 ciphertext, and the wrapped key
+const stanzaSize = 32 + mlkem.CiphertextSize768 + 32
+
+// Generate an identity for receiving volumes, made of an X25519 key and an ML-KEM-768 key
+func createIdentity(path string, name string) error {
+	x, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	kem, err := mlkem.GenerateKey768()
+	if err != nil {
+		panic(err)
+	}
+	private := append(x.Bytes(), kem.Bytes()...)
+	public := append(x.PublicKey().Bytes(), kem.EncapsulationKey().Bytes()...)
+	return saveKeypair(path, name, "PICOCRYPT IDENTITY", private, "PICOCRYPT RECIPIENT", public)
+}
+
+// Read an identity created by createIdentity
+func readIdentity(path string) (*pem.Block, error) {
+	return readKey(path, "PICOCRYPT IDENTITY", 32+mlkem.SeedSize)
+}
+
+// Read a recipient's public key created by createIdentity, making sure it can be encrypted to
+func readRecipient(path string) (*pem.Block, error) {
+	block, err := readKey(path, "PICOCRYPT RECIPIENT", 32+mlkem.EncapsulationKeySize768)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mlkem.NewEncapsulationKey768(block.Bytes[32:]); err != nil {
+		return nil, err
+	}
+	x, err := ecdh.X25519().NewPublicKey(block.Bytes[:32])
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := tmp.ECDH(x); err != nil { // Low-order points give an all-zero secret
+		return nil, err
+	}
+	return block, nil
+}
+
+// Derive the key that wraps the volume key from both shared secrets, so it stays safe unless both are broken
+func wrappingKey(xShared []byte, kemShared []byte, stanza []byte, recipient []byte) []byte {
+	info := append([]byte("picocrypt recipient"), stanza[:stanzaSize-32]...)
+	info = append(info, recipient...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha3.New256, append(xShared, kemShared...), nil, info), key); err != nil {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	return key
+}
+
+// Wrap the volume key for a recipient
+func wrapKey(key []byte, recipient []byte) []byte {
+	x, err := ecdh.X25519().NewPublicKey(recipient[:32])
+	if err != nil {
+		panic(err)
+	}
+	kem, err := mlkem.NewEncapsulationKey768(recipient[32:])
+	if err != nil {
+		panic(err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	xShared, err := ephemeral.ECDH(x)
+	if err != nil {
+		panic(err)
+	}
+	kemShared, ciphertext := kem.Encapsulate()
+
+	stanza := append(ephemeral.PublicKey().Bytes(), ciphertext...)
+	wrapping := wrappingKey(xShared, kemShared, stanza, recipient)
+	for i := range key {
+		stanza = append(stanza, key[i]^wrapping[i])
+	}
+	return stanza
+}
+
+// Unwrap the volume key from a stanza with an identity, which only gives the right key if it was wrapped for it
+func unwrapKey(stanza []byte, identity []byte) []byte {
+	x, err := ecdh.X25519().NewPrivateKey(identity[:32])
+	if err != nil {
+		panic(err)
+	}
+	kem, err := mlkem.NewDecapsulationKey768(identity[32:])
+	if err != nil {
+		panic(err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(stanza[:32])
+	if err != nil {
+		return nil
+	}
+	xShared, err := x.ECDH(ephemeral)
+	if err != nil {
+		return nil
+	}
+	kemShared, err := kem.Decapsulate(stanza[32 : stanzaSize-32])
+	if err != nil {
+		return nil
+	}
+
+	recipient := append(x.PublicKey().Bytes(), kem.EncapsulationKey().Bytes()...)
+	wrapping := wrappingKey(xShared, kemShared, stanza, recipient)
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = stanza[stanzaSize-32+i] ^ wrapping[i]
+	}
+	return key
+}
+
+// Write a generated keyfile as 24 words, BIP39 style
+func keyfileToWords(data []byte) (string, error) {
+	if len(data) != 32 {
+		return "", errors.New("not a generated keyfile")
+	}
+
+	// 256 bits of keyfile followed by 8 bits of checksum, 11 bits per word
+	sum := sha256.Sum256(data)
+	bits := append(append([]byte{}, data...), sum[0])
+	var lines []string // Four words per line
+	for i := range 24 {
+		n := 0
+		for j := range 11 {
+			bit := i*11 + j
+			n = n<<1 | int(bits[bit/8]>>(7-bit%8)&1)
+		}
+		if i%4 == 0 {
+			lines = append(lines, wordlist[n])
+		} else {
+			lines[len(lines)-1] += " " + wordlist[n]
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Turn words from keyfileToWords back into the keyfile
+func wordsToKeyfile(text string) ([]byte, error) {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) != 24 {
+		return nil, fmt.Errorf("expected 24 words, got %d", len(fields))
+	}
+	bits := make([]byte, 33)
+	for i, word := range fields {
+		// The first four letters of every word are unique, so they're enough
+		n := -1
+		for j, w := range wordlist {
+			if w == word || (len(word) >= 4 && strings.HasPrefix(w, word)) {
+				n = j
+				break
+			}
+		}
+		if n < 0 {
+			return nil, errors.New("unknown word \"" + word + "\"")
+		}
+		for j := range 11 {
+			bit := i*11 + j
+			bits[bit/8] |= byte(n>>(10-j)&1) << (7 - bit%8)
+		}
+	}
+	if sum := sha256.Sum256(bits[:32]); sum[0] != bits[32] {
+		return nil, errors.New("wrong checksum")
+	}
+	return bits[:32], nil
+}
+
+// Read a generated keyfile as words
+func exportWords(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if stat.Size() != 32 {
+		return "", errors.New("not a generated keyfile")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return keyfileToWords(data)
+}
+
+// Paper backups are QR codes of version 10 with medium error correction
+const qrVersion = 10
+const qrSize = 17 + 4*qrVersion // Modules per side
+const qrData = 216              // Data codewords
+const qrECC = 26                // Error correction codewords per block
+
+var qrBlocks = []int{43, 43, 43, 43, 44} // Data codewords per block
+
+// Each QR code holds a header and a Reed-Solomon share of the backup
+const paperHeader = 17
+const paperShare = 192
+const maxPaper = 32 * KiB
+
+var errNoPaper = errors.New("no paper backup found")
+
+// Log and antilog tables of GF(2^8) with the polynomial used by QR codes
+var qrExp, qrLog = func() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := 1
+	for i := range 255 {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x > 255 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}()
+
+func qrMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return qrExp[int(qrLog[a])+int(qrLog[b])]
+}
+
+func qrDiv(a byte, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return qrExp[int(qrLog[a])+255-int(qrLog[b])]
+}
+
+// The generator polynomial with roots a^0 to a^25, without its leading 1
+var qrGenerator = func() []byte {
+	gen := []byte{1}
+	for i := range qrECC {
+		next := make([]byte, len(gen)+1)
+		for j, c := range gen {
+			next[j] ^= c
+			next[j+1] ^= qrMul(c, qrExp[i])
+		}
+		gen = next
+	}
+	return gen[1:]
+}()
+
+// Compute the error correction codewords of a block
+func qrEncodeBlock(data []byte) []byte {
+	ecc := make([]byte, qrECC)
+	for _, b := range data {
+		factor := b ^ ecc[0]
+		copy(ecc, ecc[1:])
+		ecc[qrECC-1] = 0
+		for i, g := range qrGenerator {
+			ecc[i] ^= qrMul(g, factor)
+		}
+	}
+	return ecc
+}
+
+// Correct the errors in a block of data and error correction codewords in place
+func qrCorrectBlock(block []byte) bool {
+	syndromes := make([]byte, qrECC)
+	clean := true
+	for i := range syndromes {
+		for _, b := range block {
+			syndromes[i] = qrMul(syndromes[i], qrExp[i]) ^ b
+		}
+		clean = clean && syndromes[i] == 0
+	}
+	if clean {
+		return true
+	}
+
+	// Find the error locator with Berlekamp-Massey, lowest degree first
+	locator, prev := []byte{1}, []byte{1}
+	errs, shift, last := 0, 1, byte(1)
+	for i := range qrECC {
+		delta := syndromes[i]
+		for j := 1; j <= errs && j < len(locator); j++ {
+			delta ^= qrMul(locator[j], syndromes[i-j])
+		}
+		if delta == 0 {
+			shift++
+			continue
+		}
+		next := append([]byte{}, locator...)
+		for len(next) < len(prev)+shift {
+			next = append(next, 0)
+		}
+		for j, c := range prev {
+			next[j+shift] ^= qrMul(qrDiv(delta, last), c)
+		}
+		if 2*errs <= i {
+			errs, prev, last, shift = i+1-errs, locator, delta, 1
+		} else {
+			shift++
+		}
+		locator = next
+	}
+	if 2*errs > qrECC {
+		return false
+	}
+
+	// The error evaluator, the syndromes times the locator
+	evaluator := make([]byte, qrECC)
+	for i := range evaluator {
+		for j := 0; j <= i && j < len(locator); j++ {
+			evaluator[i] ^= qrMul(locator[j], syndromes[i-j])
+		}
+	}
+
+	// Find where the errors are and fix them with Forney's algorithm
+	eval := func(poly []byte, x byte) byte {
+		var res byte
+		for i := len(poly) - 1; i >= 0; i-- {
+			res = qrMul(res, x) ^ poly[i]
+		}
+		return res
+	}
+	found := 0
+	for k := range block {
+		power := len(block) - 1 - k
+		inverse := qrExp[(255-power)%255]
+		if eval(locator, inverse) != 0 {
+			continue
+		}
+		var derivative byte
+		for j := 1; j < len(locator); j += 2 {
+			derivative ^= qrMul(locator[j], qrExp[(255-power)*(j-1)%255])
+		}
+		if derivative == 0 {
+			return false
+		}
+		block[k] ^= qrMul(qrExp[power], qrDiv(eval(evaluator, inverse), derivative))
+		found++
+	}
+	if found != errs {
+		return false
+	}
+
+	// Make sure the corrections are actually correct
+	for i := range qrECC {
+		var syndrome byte
+		for _, b := range block {
+			syndrome = qrMul(syndrome, qrExp[i]) ^ b
+		}
+		if syndrome != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Draw the patterns that are in every QR code, leaving room for the format information
+func qrPatterns() (dark [qrSize][qrSize]bool, function [qrSize][qrSize]bool) {
+	set := func(x int, y int, d bool) {
+		dark[y][x] = d
+		function[y][x] = true
+	}
+
+	// Timing patterns
+	for i := range qrSize {
+		set(6, i, i%2 == 0)
+		set(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns and their separators
+	for _, c := range [][2]int{{3, 3}, {qrSize - 4, 3}, {3, qrSize - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < qrSize && y >= 0 && y < qrSize {
+					d := max(dx, -dx, dy, -dy)
+					set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+
+	// Alignment patterns, except where the finder patterns are
+	centers := []int{6, 28, 50}
+	for i, cx := range centers {
+		for j, cy := range centers {
+			if (i == 0 && j == 0) || (i == 0 && j == 2) || (i == 2 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					set(cx+dx, cy+dy, max(dx, -dx, dy, -dy) != 1)
+				}
+			}
+		}
+	}
+
+	// Format information, which depends on the mask
+	first, second := qrFormatModules()
+	for i := range 15 {
+		set(first[i][0], first[i][1], false)
+		set(second[i][0], second[i][1], false)
+	}
+	set(8, qrSize-8, true)
+
+	// Version information
+	rem := qrVersion
+	for range 12 {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	version := qrVersion<<12 | rem
+	for i := range 18 {
+		d := version>>i&1 == 1
+		set(qrSize-11+i%3, i/3, d)
+		set(i/3, qrSize-11+i%3, d)
+	}
+	return
+}
+
+// Where each bit of the two copies of the format information goes
+func qrFormatModules() (first [15][2]int, second [15][2]int) {
+	for i := range 15 {
+		switch {
+		case i < 6:
+			first[i] = [2]int{8, i}
+		case i < 8:
+			first[i] = [2]int{8, i + 1}
+		case i == 8:
+			first[i] = [2]int{7, 8}
+		default:
+			first[i] = [2]int{14 - i, 8}
+		}
+		if i < 8 {
+			second[i] = [2]int{qrSize - 1 - i, 8}
+		} else {
+			second[i] = [2]int{8, qrSize - 15 + i}
+		}
+	}
+	return
+}
+
+// The format information for medium error correction and a mask
+func qrFormat(mask int) int {
+	rem := mask
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (mask<<10 | rem) ^ 0x5412
+}
+
+func qrMasked(mask int, x int, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	}
+	return ((x+y)%2+x*y%3)%2 == 0
+}
+
+// Visit the data modules in the order that codewords are placed
+func qrZigzag(function *[qrSize][qrSize]bool, visit func(x int, y int)) {
+	for right := qrSize - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range qrSize {
+			for j := range 2 {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = qrSize - 1 - vert
+				}
+				if !function[y][x] {
+					visit(x, y)
+				}
+			}
+		}
+	}
+}
+
+// Score how hard a QR code is to scan, lower is better
+func qrPenalty(code *[qrSize][qrSize]bool) int {
+	score, dark := 0, 0
+	for a := range qrSize {
+		for _, vertical := range []bool{false, true} {
+			at := func(i int) bool {
+				if vertical {
+					return code[i][a]
+				}
+				return code[a][i]
+			}
+
+			// Long runs of the same color
+			run := 1
+			for i := 1; i <= qrSize; i++ {
+				if i < qrSize && at(i) == at(i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			// Patterns that look like finder patterns
+			for i := 0; i+11 <= qrSize; i++ {
+				pattern := 0
+				for j := range 11 {
+					pattern <<= 1
+					if at(i + j) {
+						pattern |= 1
+					}
+				}
+				if pattern == 0x5d0 || pattern == 0x05d {
+					score += 40
+				}
+			}
+		}
+
+		// Blocks of the same color
+		for b := range qrSize {
+			if code[a][b] {
+				dark++
+			}
+			if a+1 < qrSize && b+1 < qrSize && code[a][b] == code[a][b+1] &&
+				code[a][b] == code[a+1][b] && code[a][b] == code[a+1][b+1] {
+				score += 3
+			}
+		}
+	}
+
+	// Too many dark or light modules
+	total := qrSize * qrSize
+	return score + ((max(dark*20-total*10, total*10-dark*20)+total-1)/total-1)*10
+}
+
+// Make a QR code holding 'payload' in byte mode
+func qrEncode(payload []byte) *[qrSize][qrSize]bool {
+	// Mode, length, payload, and terminator, padded to fill the code
+	stream := make([]byte, 0, qrData)
+	stream = append(stream, 0x40|byte(len(payload)>>12), byte(len(payload)>>4), byte(len(payload)<<4))
+	for _, b := range payload {
+		stream[len(stream)-1] |= b >> 4
+		stream = append(stream, b<<4)
+	}
+	for i := 0; len(stream) < qrData; i++ {
+		stream = append(stream, []byte{0xec, 0x11}[i%2])
+	}
+
+	// Split into blocks, add error correction, and interleave them
+	var blocks, eccs [][]byte
+	for _, n := range qrBlocks {
+		blocks = append(blocks, stream[:n])
+		eccs = append(eccs, qrEncodeBlock(stream[:n]))
+		stream = stream[n:]
+	}
+	var codewords []byte
+	for i := range qrBlocks[len(qrBlocks)-1] {
+		for _, block := range blocks {
+			if i < len(block) {
+				codewords = append(codewords, block[i])
+			}
+		}
+	}
+	for i := range qrECC {
+		for _, ecc := range eccs {
+			codewords = append(codewords, ecc[i])
+		}
+	}
+
+	// Place the codewords
+	dark, function := qrPatterns()
+	i := 0
+	qrZigzag(&function, func(x int, y int) {
+		dark[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+		i++
+	})
+
+	// Use the mask that makes the code easiest to scan
+	var best *[qrSize][qrSize]bool
+	bestPenalty := 0
+	for mask := range 8 {
+		code := dark
+		for y := range qrSize {
+			for x := range qrSize {
+				if !function[y][x] && qrMasked(mask, x, y) {
+					code[y][x] = !code[y][x]
+				}
+			}
+		}
+		format := qrFormat(mask)
+		first, second := qrFormatModules()
+		for i := range 15 {
+			code[first[i][1]][first[i][0]] = format>>i&1 == 1
+			code[second[i][1]][second[i][0]] = format>>i&1 == 1
+		}
+		if penalty := qrPenalty(&code); best == nil || penalty < bestPenalty {
+			best, bestPenalty = &code, penalty
+		}
+	}
+	return best
+}
+
+// Read the payload of a QR code made by qrEncode, correcting errors
+func qrDecode(code *[qrSize][qrSize]bool) ([]byte, bool) {
+	// Find the mask from whichever copy of the format information is readable
+	mask := -1
+	first, second := qrFormatModules()
+	for _, modules := range [][15][2]int{first, second} {
+		format := 0
+		for i, m := range modules {
+			if code[m[1]][m[0]] {
+				format |= 1 << i
+			}
+		}
+		for i := range 8 {
+			if bits.OnesCount(uint(format^qrFormat(i))) <= 3 {
+				mask = i
+			}
+		}
+		if mask >= 0 {
+			break
+		}
+	}
+	if mask < 0 {
+		return nil, false
+	}
+
+	// Read and unmask the codewords
+	_, function := qrPatterns()
+	codewords := make([]byte, qrData+qrECC*len(qrBlocks))
+	i := 0
+	qrZigzag(&function, func(x int, y int) {
+		if code[y][x] != qrMasked(mask, x, y) {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+		i++
+	})
+
+	// Deinterleave and correct the blocks
+	blocks := make([][]byte, len(qrBlocks))
+	for i := range qrBlocks[len(qrBlocks)-1] {
+		for j, n := range qrBlocks {
+			if i < n {
+				blocks[j] = append(blocks[j], codewords[0])
+				codewords = codewords[1:]
+			}
+		}
+	}
+	for range qrECC {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[0])
+			codewords = codewords[1:]
+		}
+	}
+	var stream []byte
+	for j, n := range qrBlocks {
+		if !qrCorrectBlock(blocks[j]) {
+			return nil, false
+		}
+		stream = append(stream, blocks[j][:n]...)
+	}
+
+	// Only byte mode is used
+	length := int(stream[0]&15)<<12 | int(stream[1])<<4 | int(stream[2]>>4)
+	if stream[0]>>4 != 4 || length > qrData-3 {
+		return nil, false
+	}
+	payload := make([]byte, length)
+	for i := range payload {
+		payload[i] = stream[2+i]<<4 | stream[3+i]>>4
+	}
+	return payload, true
+}
+
+// A finder pattern in the corner of a QR code
+type qrFinder struct {
+	x      float64
+	y      float64
+	module float64
+	count  int
+}
+
+// Whether run lengths have the 1:1:3:1:1 ratio of a finder pattern
+func qrFinderRatio(runs [5]int) bool {
+	total := 0
+	for _, i := range runs {
+		total += i
+	}
+	if total < 7 {
+		return false
+	}
+	module := float64(total) / 7
+	for i, n := range runs {
+		expected := module
+		if i == 2 {
+			expected *= 3
+		}
+		if math.Abs(float64(n)-expected) >= expected/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Measure a finder pattern along a line through 'center' and find its middle
+func qrCrossCheck(black func(int) bool, center int, total int) (float64, bool) {
+	var runs [5]int
+	i, j := center, center+1
+	for ; black(i) && runs[2] <= total; i-- {
+		runs[2]++
+	}
+	for ; !black(i) && runs[1] <= total; i-- {
+		runs[1]++
+	}
+	for ; black(i) && runs[0] <= total; i-- {
+		runs[0]++
+	}
+	for ; black(j) && runs[2] <= total; j++ {
+		runs[2]++
+	}
+	for ; !black(j) && runs[3] <= total; j++ {
+		runs[3]++
+	}
+	for ; black(j) && runs[4] <= total; j++ {
+		runs[4]++
+	}
+	sum := runs[0] + runs[1] + runs[2] + runs[3] + runs[4]
+	if !qrFinderRatio(runs) || 5*max(sum-total, total-sum) >= 2*total {
+		return 0, false
+	}
+	return float64(j-runs[4]-runs[3]) - float64(runs[2])/2, true
+}
+
+// Find the alignment pattern in the bottom right corner of a QR code within 'window' modules of where
+// 'transform' (from modules to the image) puts it, by matching its 5x5 modules at every pixel around there
+func qrFindAlignment(black func(int, int) bool, transform func(float64, float64) (float64, float64), window float64) (float64, float64, bool) {
+	c := float64(qrSize - 7)
+	px, py := transform(c, c)
+	rx, ry := transform(c+1, c)
+	dx, dy := transform(c, c+1)
+	rx, ry, dx, dy = rx-px, ry-py, dx-px, dy-py
+	radius := int(window * max(math.Hypot(rx, ry), math.Hypot(dx, dy)))
+	if radius > 200 { // Too large to be a QR code made by qrEncode
+		return 0, 0, false
+	}
+
+	// Average the positions where the most modules match, since it matches over about a module
+	best, sumX, sumY, count := 0, 0, 0, 0
+	for oy := -radius; oy <= radius; oy++ {
+		for ox := -radius; ox <= radius; ox++ {
+			score := 0
+			for j := -2; j <= 2; j++ {
+				for i := -2; i <= 2; i++ {
+					x := px + float64(ox) + float64(i)*rx + float64(j)*dx
+					y := py + float64(oy) + float64(i)*ry + float64(j)*dy
+					if black(int(x), int(y)) == (max(i, -i, j, -j) != 1) {
+						score++
+					}
+				}
+			}
+			if score > best {
+				best, sumX, sumY, count = score, 0, 0, 0
+			}
+			if score == best {
+				sumX, sumY, count = sumX+ox, sumY+oy, count+1
+			}
+		}
+	}
+	return px + float64(sumX)/float64(count), py + float64(sumY)/float64(count), best >= 23
+}
+
+// Find the perspective transform that maps each of four points to another, with Gaussian elimination
+func qrHomography(from [4][2]float64, to [4][2]float64) (func(float64, float64) (float64, float64), bool) {
+	var m [8][9]float64
+	for i := range 4 {
+		x, y, u, v := from[i][0], from[i][1], to[i][0], to[i][1]
+		m[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		m[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+	for col := range 8 {
+		pivot := col
+		for row := col + 1; row < 8; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-9 {
+			return nil, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for row := range 8 {
+			if row != col {
+				f := m[row][col] / m[col][col]
+				for k := col; k < 9; k++ {
+					m[row][k] -= f * m[col][k]
+				}
+			}
+		}
+	}
+	var h [8]float64
+	for i := range h {
+		h[i] = m[i][8] / m[i][i]
+	}
+	return func(x float64, y float64) (float64, float64) {
+		w := h[6]*x + h[7]*y + 1
+		return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w
+	}, true
+}
+
+// Find and read every QR code made by qrEncode in a scanned image
+func qrScan(img image.Image) [][]byte {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	gray := make([]byte, w*h)
+	var histogram [256]int
+	for y := range h {
+		for x := range w {
+			c := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			gray[y*w+x] = c
+			histogram[c]++
+		}
+	}
+
+	// Separate dark from light with Otsu's method
+	sum, sumDark, countDark := 0, 0, 0
+	for i, n := range histogram {
+		sum += i * n
+	}
+	threshold, best := 128, 0.0
+	for i, n := range histogram {
+		countDark += n
+		sumDark += i * n
+		if countDark == 0 || countDark == w*h {
+			continue
+		}
+		diff := float64(sumDark)/float64(countDark) - float64(sum-sumDark)/float64(w*h-countDark)
+		if variance := float64(countDark) * float64(w*h-countDark) * diff * diff; variance > best {
+			threshold, best = i+1, variance
+		}
+	}
+
+	// Clean up specks of noise by taking the majority of each pixel's neighbors
+	dark := make([]bool, w*h)
+	for y := range h {
+		for x := range w {
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if x+dx >= 0 && y+dy >= 0 && x+dx < w && y+dy < h && int(gray[(y+dy)*w+x+dx]) < threshold {
+						n++
+					}
+				}
+			}
+			dark[y*w+x] = n >= 5
+		}
+	}
+	black := func(x int, y int) bool {
+		return x >= 0 && y >= 0 && x < w && y < h && dark[y*w+x]
+	}
+
+	// Look for finder patterns row by row, checking each one vertically and then horizontally
+	var finders []qrFinder
+	for y := range h {
+		var starts, lengths []int
+		for x := 0; x < w; {
+			start := x
+			for x < w && black(x, y) == black(start, y) {
+				x++
+			}
+			starts, lengths = append(starts, start), append(lengths, x-start)
+		}
+		for i := 0; i+5 <= len(lengths); i++ {
+			if !black(starts[i], y) {
+				continue
+			}
+			runs := [5]int(lengths[i : i+5])
+			if !qrFinderRatio(runs) {
+				continue
+			}
+			total := runs[0] + runs[1] + runs[2] + runs[3] + runs[4]
+			cx := starts[i+2] + runs[2]/2
+			cy, ok := qrCrossCheck(func(j int) bool { return black(cx, j) }, y, total)
+			if !ok {
+				continue
+			}
+			x, ok := qrCrossCheck(func(j int) bool { return black(j, int(cy)) }, cx, total)
+			if !ok {
+				continue
+			}
+
+			// Merge with the same finder pattern from earlier rows
+			module := float64(total) / 7
+			merged := false
+			for k := range finders {
+				f := &finders[k]
+				if math.Abs(f.x-x) < 2*f.module && math.Abs(f.y-cy) < 2*f.module {
+					n := float64(f.count)
+					f.x, f.y, f.module = (f.x*n+x)/(n+1), (f.y*n+cy)/(n+1), (f.module*n+module)/(n+1)
+					f.count++
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				finders = append(finders, qrFinder{x, cy, module, 1})
+			}
+		}
+	}
+
+	// Try every three finder patterns that could be the corners of a QR code
+	var payloads [][]byte
+	for c, corner := range finders {
+		for i, a := range finders {
+			for j, b := range finders[i+1:] {
+				if c == i || c == i+1+j || corner.count < 2 || a.count < 2 || b.count < 2 {
+					continue
+				}
+				ax, ay, bx, by := a.x-corner.x, a.y-corner.y, b.x-corner.x, b.y-corner.y
+				da, db := math.Hypot(ax, ay), math.Hypot(bx, by)
+				modules := (da + db) / 2 / corner.module
+				// Codes photographed at an angle can be skewed by up to about 20 degrees
+				if math.Abs(da-db) > 0.25*da || math.Abs(ax*bx+ay*by) > 0.35*da*db || modules < 30 || modules > 80 {
+					continue
+				}
+
+				// The top right corner is clockwise from the bottom left one
+				right, down := a, b
+				if ax*by-ay*bx < 0 {
+					right, down = b, a
+				}
+
+				// Map modules to the image from the finder patterns alone, and then, if the alignment pattern
+				// in the bottom right corner can be found, from all four to correct for perspective
+				affine := func(x float64, y float64) (float64, float64) {
+					u, v := (x-3)/(qrSize-7), (y-3)/(qrSize-7)
+					return corner.x + (right.x-corner.x)*u + (down.x-corner.x)*v, corner.y + (right.y-corner.y)*u + (down.y-corner.y)*v
+				}
+				transforms := []func(float64, float64) (float64, float64){affine}
+				from := [4][2]float64{{3, 3}, {qrSize - 4, 3}, {3, qrSize - 4}, {qrSize - 7, qrSize - 7}}
+				to := [4][2]float64{{corner.x, corner.y}, {right.x, right.y}, {down.x, down.y}}
+				if x, y, ok := qrFindAlignment(black, affine, 5); ok {
+					to[3] = [2]float64{x, y}
+					if transform, ok := qrHomography(from, to); ok {
+						// Look again where the first estimate says the pattern is, which is closer
+						if x, y, ok := qrFindAlignment(black, transform, 1); ok {
+							to[3] = [2]float64{x, y}
+							if refined, ok := qrHomography(from, to); ok {
+								transform = refined
+							}
+						}
+						transforms = append([]func(float64, float64) (float64, float64){transform}, transforms...)
+					}
+				}
+				var payload []byte
+				ok := false
+				for _, transform := range transforms {
+					var code [qrSize][qrSize]bool
+					for y := range qrSize {
+						for x := range qrSize {
+							px, py := transform(float64(x), float64(y))
+							code[y][x] = black(int(px), int(py))
+						}
+					}
+					if payload, ok = qrDecode(&code); ok {
+						break
+					}
+				}
+				if !ok {
+					continue
+				}
+				duplicate := false
+				for _, p := range payloads {
+					if bytes.Equal(p, payload) {
+						duplicate = true
+					}
+				}
+				if !duplicate {
+					payloads = append(payloads, payload)
+				}
+			}
+		}
+	}
+	return payloads
+}
+
+// Split data into Reed-Solomon shares that each fit in a QR code, so that some can be lost
+func paperChunks(data []byte) [][]byte {
+	required := (len(data) + paperShare - 1) / paperShare
+	total := required + max(2, (required+3)/4)
+	fec, err := infectious.NewFEC(required, total)
+	if err != nil {
+		panic(err)
+	}
+
+	// The header says how to put the shares back together
+	sum := sha3.Sum256(data)
+	header := make([]byte, paperHeader)
+	copy(header, "pc")
+	header[2], header[3] = byte(required), byte(total)
+	binary.LittleEndian.PutUint32(header[5:9], uint32(len(data)))
+	copy(header[9:], sum[:8])
+
+	padded := make([]byte, required*paperShare)
+	copy(padded, data)
+	chunks := make([][]byte, total)
+	fec.Encode(padded, func(s infectious.Share) {
+		chunk := append([]byte{}, header...)
+		chunk[4] = byte(s.Number)
+		chunks[s.Number] = append(chunk, s.Data...)
+	})
+	return chunks
+}
+
+// Put the data back together from the chunks in scanned QR codes
+func paperJoin(chunks [][]byte) ([]byte, error) {
+	var header []byte
+	var shares []infectious.Share
+	seen := make(map[byte]bool)
+	for _, chunk := range chunks {
+		if len(chunk) != paperHeader+paperShare || string(chunk[:2]) != "pc" {
+			continue
+		}
+		if header == nil {
+			header = chunk[:paperHeader]
+		}
+
+		// Only use chunks from the same backup as the first one
+		if !bytes.Equal(chunk[:4], header[:4]) || !bytes.Equal(chunk[5:paperHeader], header[5:]) || seen[chunk[4]] {
+			continue
+		}
+		if chunk[4] >= header[3] {
+			return nil, errors.New("invalid QR code")
+		}
+		seen[chunk[4]] = true
+		shares = append(shares, infectious.Share{Number: int(chunk[4]), Data: chunk[paperHeader:]})
+	}
+	if header == nil {
+		return nil, errNoPaper
+	}
+	if len(shares) < int(header[2]) {
+		return nil, fmt.Errorf("%d more QR codes are needed", int(header[2])-len(shares))
+	}
+
+	fec, err := infectious.NewFEC(int(header[2]), int(header[3]))
+	if err != nil {
+		return nil, errors.New("invalid QR code")
+	}
+	data, err := fec.Decode(nil, shares)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header[5:9]))
+	if length > len(data) {
+		return nil, errors.New("invalid QR code")
+	}
+	data = data[:length]
+	if sum := sha3.Sum256(data); !bytes.Equal(sum[:8], header[9:]) {
+		return nil, errors.New("the checksum doesn't match")
+	}
+	return data, nil
+}
+
+// A checksum short enough to compare by eye
+func paperChecksum(data []byte) string {
+	sum := sha3.Sum256(data)
+	s := hex.EncodeToString(sum[:8])
+	return s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+}
+
+// A tiny font for page numbers and checksums
+var paperFont = map[rune][7]byte{
+	'0': {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1': {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3': {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4': {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5': {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6': {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8': {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9': {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'a': {0b00000, 0b00000, 0b01110, 0b00001, 0b01111, 0b10001, 0b01111},
+	'b': {0b10000, 0b10000, 0b10110, 0b11001, 0b10001, 0b10001, 0b11110},
+	'c': {0b00000, 0b00000, 0b01110, 0b10000, 0b10000, 0b10001, 0b01110},
+	'd': {0b00001, 0b00001, 0b01101, 0b10011, 0b10001, 0b10001, 0b01111},
+	'e': {0b00000, 0b00000, 0b01110, 0b10001, 0b11111, 0b10000, 0b01110},
+	'f': {0b00110, 0b01001, 0b01000, 0b11100, 0b01000, 0b01000, 0b01000},
+	'/': {0b00000, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b00000},
+	'-': {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+}
+
+// Draw text in the tiny font, 'scale' pixels per dot
+func paperText(img *image.Gray, x int, y int, scale int, text string) {
+	for _, r := range text {
+		for row, line := range paperFont[r] {
+			for col := range 5 {
+				if line>>(4-col)&1 == 1 {
+					for i := range scale * scale {
+						img.Pix[(y+row*scale+i/scale)*img.Stride+x+col*scale+i%scale] = 0
+					}
+				}
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+// Draw a paper backup of data on A4 pages at 200 DPI, 35 QR codes per page
+func paperPages(data []byte) []*image.Gray {
+	chunks := paperChunks(data)
+	pages := make([]*image.Gray, (len(chunks)+34)/35)
+	for p := range pages {
+		img := image.NewGray(image.Rect(0, 0, 1654, 2339))
+		for i := range img.Pix {
+			img.Pix[i] = 0xff
+		}
+		paperText(img, 97, 60, 4, fmt.Sprintf("%d/%d  %s", p+1, len(pages), paperChecksum(data)))
+
+		// 4 pixels per module, with room for the quiet zone and a label under each code
+		for i := p * 35; i < min(len(chunks), (p+1)*35); i++ {
+			x0, y0 := 113+i%35%5*300, 156+i%35/5*300
+			code := qrEncode(chunks[i])
+			for y := range qrSize {
+				for x := range qrSize {
+					if code[y][x] {
+						for j := range 16 {
+							img.Pix[(y0+y*4+j/4)*img.Stride+x0+x*4+j%4] = 0
+						}
+					}
+				}
+			}
+			paperText(img, x0, y0+qrSize*4+18, 2, fmt.Sprintf("%d/%d", i+1, len(chunks)))
+		}
+		pages[p] = img
+	}
+	return pages
+}
+
+// Put pages in a PDF, each one filling an A4 sheet
+func paperPDF(pages []*image.Gray) []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(dict string, stream []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\n", len(offsets), dict)
+		if stream != nil {
+			buf.WriteString("stream\n")
+			buf.Write(stream)
+			buf.WriteString("\nendstream\n")
+		}
+		buf.WriteString("endobj\n")
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	var kids []string
+	for i := range pages {
+		kids = append(kids, strconv.Itoa(3+i*3)+" 0 R")
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)), nil)
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /XObject << /Im %d 0 R >> >> /Contents %d 0 R >>", 5+i*3, 4+i*3), nil)
+		contents := []byte("q 595 0 0 842 0 0 cm /Im Do Q")
+		object(fmt.Sprintf("<< /Length %d >>", len(contents)), contents)
+		var compressed bytes.Buffer
+		z := zlib.NewWriter(&compressed)
+		z.Write(page.Pix)
+		z.Close()
+		size := page.Bounds().Size()
+		object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>", size.X, size.Y, compressed.Len()), compressed.Bytes())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, i := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", i)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// Print a paper backup of a file to a PDF, or a PNG if it fits on one page
+func exportPaper(path string, output string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if stat.Size() == 0 || stat.Size() > maxPaper {
+		return "", errors.New("only files up to 32 KiB fit on paper")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	pages := paperPages(data)
+	if strings.EqualFold(filepath.Ext(output), ".pdf") {
+		err = os.WriteFile(output, paperPDF(pages), 0600)
+	} else if len(pages) > 1 {
+		return "", errors.New("more than one page is needed, save as a PDF instead")
+	} else {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, pages[0]); err != nil {
+			panic(err)
+		}
+		err = os.WriteFile(output, buf.Bytes(), 0600)
+	}
+	return paperChecksum(data), err
+}
+
+// Save data restored from words or a paper backup, using it as a keyfile if it is one
+func restoreBackup(data []byte) {
+	f := dialog.File().Title("Choose where to save the restored volume")
+	f.SetInitFilename("restored-" + strconv.Itoa(int(time.Now().Unix())) + ".pcv")
+	if len(data) == 32 {
+		f = dialog.File().Title("Choose where to save the keyfile")
+		f.SetInitFilename("keyfile-" + strconv.Itoa(int(time.Now().Unix())) + ".bin")
+	}
+	file, err := f.Save()
+	if file == "" || err != nil {
+		return
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		mainStatus = "Failed to save the restored file"
+		mainStatusColor = RED
+		giu.Update()
+		return
+	}
+	if len(data) != 32 {
+		mainStatus = "Restored, checksum " + paperChecksum(data)
+		mainStatusColor = GREEN
+		modalId++
+		giu.Update()
+		return
+	}
+
+	// Use the restored keyfile as if it was dropped in
+	keyfileWords = ""
+	backupFile = ""
+	showBackup = false
+	showKeyfile = true
+	onDrop([]string{file})
+}
+
+// Read a paper backup back from scans of its pages
+func importPaper(paths []string) ([]byte, error) {
+	var chunks [][]byte
+	for _, path := range paths {
+		fin, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(fin)
+		fin.Close()
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, qrScan(img)...)
+	}
+	return paperJoin(chunks)
+}
+
+// Multiply in GF(2^8) with the AES polynomial, as used by Shamir's secret sharing below
+func gfMul(a byte, b byte) byte {
+	var res byte
+	for range 8 {
+		res ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return res
+}
+
+// Invert in GF(2^8), since a^254 = a^-1
+func gfInv(a byte) byte {
+	res := a
+	for range 6 {
+		res = gfMul(gfMul(res, res), a)
+	}
+	return gfMul(res, res)
+}
+
+// Split a secret into 'total' shares, any 'needed' of which can recover it
+func splitSecret(secret []byte, needed int, total int) [][]byte {
+	shares := make([][]byte, total)
+	for i := range shares {
+		shares[i] = []byte{byte(needed), byte(i + 1)}
+	}
+	coefficients := make([]byte, needed)
+	for _, b := range secret {
+		// A random polynomial of degree 'needed'-1 that goes through the secret byte at x = 0
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			panic(err)
+		}
+		for i := range shares {
+			x, y := byte(i+1), byte(0)
+			for j := needed - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coefficients[j]
+			}
+			shares[i] = append(shares[i], y)
+		}
+	}
+	return shares
+}
+
+// Recover a secret from shares made by splitSecret using Lagrange interpolation at x = 0
+func combineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 || len(shares) < int(shares[0][0]) {
+		return nil, errors.New("not enough shares")
+	}
+	if shares[0][0] < 2 {
+		return nil, errors.New("invalid share")
+	}
+	shares = shares[:shares[0][0]]
+	for i, a := range shares {
+		if a[1] == 0 || len(a) != len(shares[0]) {
+			return nil, errors.New("invalid share")
+		}
+		for _, b := range shares[:i] {
+			if a[1] == b[1] {
+				return nil, errors.New("duplicate shares")
+			}
+		}
+	}
+	secret := make([]byte, len(shares[0])-2)
+	for i, a := range shares {
+		// Basis polynomial for this share, evaluated at x = 0
+		basis := byte(1)
+		for j, b := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(b[1], gfInv(a[1]^b[1])))
+			}
+		}
+		for k := range secret {
+			secret[k] ^= gfMul(a[k+2], basis)
+		}
+	}
+	return secret, nil
+}
+
+// Show how many shares will be generated
+func updateSharesLabel() {
+	if shares {
+		keyfileLabel = fmt.Sprintf("%d of %d shares", sharesNeeded, sharesTotal)
+	} else {
+		keyfileLabel = "None selected"
+	}
+}
+
+// Where to write the shares of a volume's keyfile key
+func sharePath(n int) string {
+	return fmt.Sprintf("%s.share%d", strings.TrimSuffix(outputFile, ".pcv"), n)
+}
+
+// Write a share made by splitSecret as a keyfile
+func writeShare(path string, share []byte, total int) error {
+	headers := map[string]string{
+		"Share":  fmt.Sprintf("%d of %d", share[1], total),
+		"Needed": strconv.Itoa(int(share[0])),
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PICOCRYPT SHARE", Headers: headers, Bytes: share}), 0600)
+}
+
+// Read a share written by writeShare
+func readShare(path string) ([]byte, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() > 4*KiB { // Don't read large keyfiles into memory
+		return nil, errors.New("not a share")
+	}
+	block, err := readKey(path, "PICOCRYPT SHARE", 34)
+	if err != nil {
+		return nil, err
+	}
+	return block.Bytes, nil
+}
+
+// Combine the keyfiles if they are shares, or hash them like normal keyfiles otherwise
+func readKeyfiles(paths []string, ordered bool) ([]byte, error) {
+	var parts [][]byte
+	for _, path := range paths {
+		share, err := readShare(path)
+		if err != nil {
+			return hashKeyfiles(paths, ordered), nil
+		}
+		parts = append(parts, share)
+	}
+	return combineShares(parts)
+}
+
+// Get how much padding to add to a payload of 'size' bytes, not counting the 8-byte length
+func paddingFor(size int64) int64 {
+	size += 8
+	amount := padAmount()
+	if padSelected == 3 { // Round up to a power of 2
+		target := int64(1)
+		for target < size && target < 1<<62 {
+			target <<= 1
+		}
+		return max(target-size, 0)
+	} else if amount == 0 {
+		return 0
+	} else if padSelected == 4 { // Add up to a percentage of the size at random
+		n, err := rand.Int(rand.Reader, big.NewInt(percentOf(size, amount)+1))
+		if err != nil {
+			panic(err)
+		}
+		return n.Int64()
+	}
+
+	// Round up to a multiple of KiB, MiB, or GiB
+	unit := amount << (10 * (padSelected + 1))
+	return (unit - size%unit) % unit
+}
+
+// The largest padding unit and percentage, which keep the padding from overflowing
+const maxPadUnit = TiB
+const maxPadPercent = 1000
+
+// Get the amount of padding chosen, or 0 if it isn't a valid amount for its unit
+func padAmount() int64 {
+	amount, err := strconv.ParseInt(padSize, 10, 64)
+	if err != nil || amount <= 0 {
+		return 0
+	}
+	if padSelected == 4 && amount > maxPadPercent {
+		return 0
+	} else if padSelected < 3 && amount > maxPadUnit>>(10*(padSelected+1)) {
+		return 0
+	}
+	return amount
+}
+
+// Get 'percent' percent of 'size', rounded down, without overflowing
+func percentOf(size int64, percent int64) int64 {
+	return size/100*percent + size%100*percent/100
+}
+
+// Get the most padding that paddingFor could add to a payload of 'size' bytes, counting the length
+func maxPaddingFor(size int64) int64 {
+	amount := padAmount()
+	if padSelected != 3 && amount == 0 {
+		return 0
+	}
+	if padSelected == 4 {
+		return percentOf(size+8, amount) + 8
+	}
+	return paddingFor(size) + 8
+}
+
+// Append padding to the payload followed by its length, filling every read
+// so the Reed-Solomon chunks stay aligned
+type paddingReader struct {
+	r      io.Reader
+	eof    bool
+	tail   int64 // Bytes of padding and length left to add
+	padLen int64
+}
+
+func (p *paddingReader) Read(data []byte) (int, error) {
+	n := 0
+	if !p.eof {
+		var err error
+		n, err = io.ReadFull(p.r, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			p.eof = true
+		} else if err != nil {
+			return n, err
+		}
+	}
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(p.padLen))
+	for ; n < len(data) && p.tail > 0; n++ {
+		if p.tail > 8 {
+			data[n] = 0
+		} else {
+			data[n] = length[8-p.tail]
+		}
+		p.tail--
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// A deniability layer over part of a file, encrypted with XChaCha20 and rekeyed every 60 GiB
+type deniableLayer struct {
+	r        io.ReaderAt
+	offset   int64  // Where the layer starts in 'r'
+	length   int64  // Size of the layer
+	key      []byte // Derived from the password and keyfiles
+	nonce    []byte // Initial XChaCha20 nonce
+	counter  uint32 // Block counter of the first byte (0 in older volumes)
+	macKey   []byte // Authenticates the layer (nil in older volumes)
+	paranoid bool   // Use HMAC-SHA3 instead of BLAKE2b
+	size     []byte // The encrypted length, which is also authenticated
+}
+
+// XOR 'data' with the keystream, starting 'pos' bytes into the layer
+func (l *deniableLayer) xor(data []byte, pos int64) {
+	for done := 0; done < len(data); {
+		size := int(min(int64(len(data)-done), 60*GiB-pos%(60*GiB)))
+		chacha := denyCipherAt(l.key, l.nonce, l.counter, pos)
+		chacha.XORKeyStream(data[done:done+size], data[done:done+size])
+		done += size
+		pos += int64(size)
+	}
+}
+
+// Read from the layer, XORing with the keystream to encrypt or decrypt
+func (l *deniableLayer) ReadAt(data []byte, off int64) (int, error) {
+	if off >= l.length {
+		return 0, io.EOF
+	}
+	want := int(min(int64(len(data)), l.length-off))
+	n, err := l.r.ReadAt(data[:want], l.offset+off)
+	l.xor(data[:n], off)
+	if err == nil && n < len(data) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Start a MAC over the ciphertext after the first MiB, to be followed by the first MiB
+// and then the encrypted length
+func (l *deniableLayer) newMAC() hash.Hash {
+	if l.paranoid {
+		return hmac.New(sha3.New512, l.macKey) // HMAC-SHA3
+	}
+	mac, err := blake2b.New512(l.macKey) // Keyed BLAKE2b
+	if err != nil {
+		panic(err)
+	}
+	return mac
+}
+
+// A deniable volume being read, which decrypts the layer as it goes and feeds
+// the ciphertext into the layer's MAC
+type deniableFile struct {
+	layer  *deniableLayer
+	file   inputReader
+	pos    int64
+	mac    hash.Hash // nil in older volumes
+	macked int64     // How far the ciphertext has gone into the MAC
+}
+
+func newDeniableFile(layer *deniableLayer, file inputReader) *deniableFile {
+	d := &deniableFile{layer: layer, file: file, macked: min(MiB, layer.length)}
+	if layer.macKey != nil {
+		d.mac = layer.newMAC()
+	}
+	return d
+}
+
+func (d *deniableFile) Read(data []byte) (int, error) {
+	if d.pos >= d.layer.length {
+		return 0, io.EOF
+	}
+	data = data[:min(int64(len(data)), d.layer.length-d.pos)]
+	n, err := d.file.ReadAt(data, d.layer.offset+d.pos)
+	if d.mac != nil && d.pos <= d.macked && d.pos+int64(n) > d.macked {
+		if _, err := d.mac.Write(data[d.macked-d.pos : n]); err != nil {
+			panic(err)
+		}
+		d.macked = d.pos + int64(n)
+	}
+	d.layer.xor(data[:n], d.pos)
+	d.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (d *deniableFile) ReadAt(data []byte, off int64) (int, error) {
+	return d.layer.ReadAt(data, off)
+}
+
+func (d *deniableFile) Seek(offset int64, whence int) (int64, error) {
+	d.pos = seekPos(d.pos, d.layer.length, offset, whence)
+	return d.pos, nil
+}
+
+func (d *deniableFile) Close() error {
+	return d.file.Close()
+}
+
+// Check the layer's MAC, reading whatever ciphertext hasn't gone into it yet
+func (d *deniableFile) verify() bool {
+	if d.mac == nil {
+		return true
+	}
+	for _, part := range [][2]int64{{d.macked, d.layer.length}, {0, min(MiB, d.layer.length)}} {
+		for pos := part[0]; pos < part[1]; {
+			data := make([]byte, min(MiB, part[1]-pos))
+			if _, err := d.file.ReadAt(data, d.layer.offset+pos); err != nil {
+				return false
+			}
+			if _, err := d.mac.Write(data); err != nil {
+				panic(err)
+			}
+			pos += int64(len(data))
+		}
+	}
+	if _, err := d.mac.Write(d.layer.size); err != nil {
+		panic(err)
+	}
+	tag := make([]byte, 64)
+	if _, err := d.file.ReadAt(tag, d.layer.offset+d.layer.length); err != nil {
+		return false
+	}
+	return hmac.Equal(tag, d.mac.Sum(nil))
+}
+
+// A deniable volume being written, which encrypts the layer as it goes. The first MiB is
+// kept in memory until the end since the volume header there is written again at the end,
+// and everything after it is only ever appended, so it can go into the MAC right away.
+type deniableWriter struct {
+	layer  *deniableLayer
+	file   *os.File
+	hidden bool   // Put the values after the layer instead of before it
+	salt   []byte // Argon2 salt
+	check  []byte // Checks the password and keyfiles when decrypting
+	head   []byte // Plaintext of the first MiB
+	pos    int64
+	mac    hash.Hash
+}
+
+// Start a layer at the end of 'file', after space for the values if it's not hidden
+func newDeniableWriter(file *os.File, hidden bool) *deniableWriter {
+	stat, err := file.Stat()
+	if err != nil {
+		panic(err)
+	}
+	offset := stat.Size()
+	if !hidden {
+		offset += 80
+	}
+	return &deniableWriter{
+		layer:  &deniableLayer{r: file, offset: offset, counter: 1},
+		file:   file,
+		hidden: hidden,
+	}
+}
+
+// Derive the layer's keys with a random salt and nonce, before anything past the first MiB is written
+func (w *deniableWriter) deriveKeys(password string, keyfileKey []byte, paranoid bool) {
+	w.salt = make([]byte, 16)
+	w.layer.nonce = make([]byte, 24)
+	if n, err := rand.Read(w.salt); err != nil || n != 16 {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+	if n, err := rand.Read(w.layer.nonce); err != nil || n != 24 {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+	if bytes.Equal(w.salt, make([]byte, 16)) || bytes.Equal(w.layer.nonce, make([]byte, 24)) {
+		panic(errors.New("fatal crypto/rand error"))
+	}
+
+	// The outer layers of a batch share a salt too, but a hidden layer keeps its own
+	// so the salt at the end can't tell it apart from the random padding
+	if batchKeys != nil && !w.hidden {
+		if batchDenySalt == nil {
+			batchDenySalt = w.salt
+		}
+		w.salt = batchDenySalt
+	}
+	w.layer.paranoid = paranoid
+	w.layer.key, w.layer.macKey, w.check = denySubkeys(deriveKey(password, w.salt, paranoid), keyfileKey, w.salt)
+	w.mac = w.layer.newMAC()
+}
+
+func (w *deniableWriter) Write(data []byte) (int, error) {
+	n := 0
+	if w.pos < MiB {
+		n = int(min(int64(len(data)), MiB-w.pos))
+		if end := int(w.pos) + n; end > len(w.head) {
+			w.head = append(w.head, make([]byte, end-len(w.head))...)
+		}
+		copy(w.head[w.pos:], data[:n])
+		w.pos += int64(n)
+		w.layer.length = max(w.layer.length, w.pos)
+		if n == len(data) {
+			return n, nil
+		}
+	}
+	if w.pos != w.layer.length {
+		return n, errors.New("deniable volumes can only be appended to after the first MiB")
+	}
+
+	// Encrypt, authenticate, and write the rest
+	tmp := make([]byte, len(data)-n)
+	copy(tmp, data[n:])
+	w.layer.xor(tmp, w.pos)
+	if _, err := w.mac.Write(tmp); err != nil {
+		panic(err)
+	}
+	m, err := w.file.WriteAt(tmp, w.layer.offset+w.pos)
+	w.pos += int64(m)
+	w.layer.length = w.pos
+	return n + m, err
+}
+
+func (w *deniableWriter) ReadAt(data []byte, off int64) (int, error) {
+	n := 0
+	if off < int64(len(w.head)) {
+		n = copy(data, w.head[off:])
+	}
+	if n < len(data) {
+		m, err := w.layer.ReadAt(data[n:], off+int64(n))
+		return n + m, err
+	}
+	return n, nil
+}
+
+func (w *deniableWriter) Seek(offset int64, whence int) (int64, error) {
+	w.pos = seekPos(w.pos, w.layer.length, offset, whence)
+	return w.pos, nil
+}
+
+func (w *deniableWriter) Name() string {
+	return w.file.Name()
+}
+
+func (w *deniableWriter) Close() error {
+	return w.file.Close()
+}
+
+// Write the encrypted first MiB, the MAC tag, and the salt, nonce, check value, and encrypted length
+func (w *deniableWriter) finish() error {
+	head := make([]byte, len(w.head))
+	copy(head, w.head)
+	w.layer.xor(head, 0)
+	if _, err := w.file.WriteAt(head, w.layer.offset); err != nil {
+		return err
+	}
+	if _, err := w.mac.Write(head); err != nil {
+		panic(err)
+	}
+
+	// Encrypt the length with the first block of keystream
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(w.layer.length))
+	denyCipherAt(w.layer.key, w.layer.nonce, 0, 0).XORKeyStream(size, size)
+	if _, err := w.mac.Write(size); err != nil {
+		panic(err)
+	}
+
+	if _, err := w.file.WriteAt(w.mac.Sum(nil), w.layer.offset+w.layer.length); err != nil {
+		return err
+	}
+	values := append(append(append(append([]byte{}, w.salt...), w.layer.nonce...), w.check...), size...)
+	offset := w.layer.offset - 80
+	if w.hidden {
+		offset = w.layer.offset + w.layer.length + 64
+	}
+	_, err := w.file.WriteAt(values, offset)
+	return err
+}
+
+// Get the new position of a Seek within something 'length' bytes long
+func seekPos(pos int64, length int64, offset int64, whence int) int64 {
+	if whence == io.SeekStart {
+		return offset
+	} else if whence == io.SeekCurrent {
+		return pos + offset
+	}
+	return length + offset
+}
+
+// Get the keystream of a deniability layer, starting 'pos' bytes in
+func denyCipherAt(key []byte, nonce []byte, counter uint32, pos int64) *chacha20.Cipher {
+	// Change nonce every 60 GiB to prevent overflow
+	for ; pos >= 60*GiB; pos -= 60 * GiB {
+		tmp := sha3.New256()
+		if _, err := tmp.Write(nonce); err != nil {
+			panic(err)
+		}
+		nonce = tmp.Sum(nil)[:24]
+		counter = 0
+	}
+	chacha, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		panic(err)
+	}
+	chacha.SetCounter(counter + uint32(pos/64))
+	skip := make([]byte, pos%64)
+	chacha.XORKeyStream(skip, skip)
+	return chacha
+}
+
+// Mix in the keyfile key and use HKDF-SHA3 to get the XChaCha20 key, MAC key,
+// and a value used to check the password and keyfiles
+func denySubkeys(key []byte, keyfileKey []byte, salt []byte) ([]byte, []byte, []byte) {
+	if keyfileKey != nil {
+		key = xorKeys(key, keyfileKey)
+	}
+	subkeys := make([]byte, 96)
+	if _, err := io.ReadFull(hkdf.New(sha3.New256, key, salt, nil), subkeys); err != nil {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	return subkeys[:32], subkeys[32:64], subkeys[64:]
+}
+
+// Find the volume inside a deniable volume that the password and keyfiles unlock. The decoy
+// volume is at the start and a hidden volume is at the end, while older deniable volumes are
+// just one encrypted stream after the salt and nonce. Paranoid mode isn't known beforehand,
+// so both strengths are tried.
+func findDeniable(fin io.ReaderAt, size int64, password string, keyfileKey []byte) *deniableLayer {
+	for _, hidden := range []bool{false, true} {
+		// Get the Argon2 salt, XChaCha20 nonce, check value, and encrypted length
+		values := make([]byte, 80)
+		offset := int64(0)
+		if hidden {
+			offset = size - 80
+		}
+		if offset < 0 {
+			break
+		}
+		if _, err := fin.ReadAt(values, offset); err != nil {
+			break
+		}
+		salt, nonce, checkRef, length := values[:16], values[16:40], values[40:72], values[72:]
+
+		for _, paranoid := range []bool{false, true} {
+			popupStatus = "Deriving key..."
+			giu.Update()
+			argonKey := deriveKey(password, salt, paranoid)
+			key, macKey, check := denySubkeys(argonKey, keyfileKey, salt)
+
+			if subtle.ConstantTimeCompare(check, checkRef) == 1 {
+				layer := &deniableLayer{
+					r:        fin,
+					key:      key,
+					nonce:    nonce,
+					counter:  1,
+					macKey:   macKey,
+					paranoid: paranoid,
+					size:     length,
+				}
+				tmp := make([]byte, 8)
+				denyCipherAt(key, nonce, 0, 0).XORKeyStream(tmp, length)
+				layer.length = int64(binary.LittleEndian.Uint64(tmp))
+				if layer.length < 0 || layer.length > size-80-64 {
+					return nil
+				}
+				layer.offset = 80
+				if hidden {
+					layer.offset = size - 80 - 64 - layer.length
+				}
+				return layer
+			}
+
+			// Older deniable volumes start right after the salt and nonce and don't use keyfiles
+			if !hidden && !paranoid {
+				layer := &deniableLayer{r: fin, offset: 40, length: size - 40, key: argonKey, nonce: nonce}
+				if isVolume(layer) {
+					return layer
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Check if the version of a volume can be read
+func isVolume(r io.ReaderAt) bool {
+	tmp := make([]byte, 15)
+	if _, err := r.ReadAt(tmp, 0); err != nil {
+		return false
+	}
+	tmp, err := rsDecode(rs5, tmp)
+	valid, _ := regexp.Match(`^v1\.\d{2}`, tmp)
+	return err == nil && valid
+}
+
+// Check that a volume only uses flags this version knows, so that one made by a newer version
+// isn't decrypted as if its new flags weren't there
+func knownFlags(flags []byte) bool {
+	return flags[0]&^63 == 0 && flags[1] <= 2 && flags[2] <= 1 && flags[3]&^3 == 0 && flags[4] <= 1
+}
+
+// Check if a volume header can be read without any Reed-Solomon errors
+func headerIntact(r io.Reader) bool {
+	tmp := make([]byte, 15)
+	if _, err := io.ReadFull(r, tmp); err != nil {
+		return false
+	}
+	tmp, err := rsDecode(rs5, tmp)
+	if valid, _ := regexp.Match(`^v\d\.\d{2}`, tmp); err != nil || !valid {
+		return false
+	}
+
+	tmp = make([]byte, 15)
+	if _, err := io.ReadFull(r, tmp); err != nil {
+		return false
+	}
+	tmp, err = rsDecode(rs5, tmp)
+	if valid, _ := regexp.Match(`^\d{5}$`, tmp); err != nil || !valid {
+		return false
+	}
+	commentsLength, _ := strconv.Atoi(string(tmp))
+	tmp = make([]byte, commentsLength*3)
+	if _, err := io.ReadFull(r, tmp); err != nil {
+		return false
+	}
+	for i := 0; i < len(tmp); i += 3 {
+		if _, err := rsDecode(rs1, tmp[i:i+3]); err != nil {
+			return false
+		}
+	}
+
+	// Flags, salts, IV, nonce, hashes, and tag
+	var flags []byte
+	intact := func(rs *infectious.FEC) bool {
+		tmp = make([]byte, rs.Total())
+		if _, err := io.ReadFull(r, tmp); err != nil {
+			return false
+		}
+		tmp, err = rsDecode(rs, tmp)
+		return err == nil
+	}
+	for _, rs := range []*infectious.FEC{rs5, rs16, rs32, rs16, rs24, rs64, rs32, rs64} {
+		if !intact(rs) {
+			return false
+		}
+		if flags == nil {
+			flags = tmp
+		}
+	}
+
+	// Public key and signature, if the volume is signed
+	if flags[0]&8 == 8 && (!intact(rs32) || !intact(rs64)) {
+		return false
+	}
+
+	// Wrapped keys, if the volume is for recipients
+	if flags[0]&16 == 16 {
+		if !intact(rs5) {
+			return false
+		}
+		count, err := strconv.Atoi(string(tmp))
+		if err != nil {
+			return false
+		}
+		for range count * stanzaSize / 32 {
+			if !intact(rs32) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Read the backup copy of the header from the end of a volume, if there is one
+func readBackupHeader(r io.ReaderAt, size int64) []byte {
+	if size < 48+789 {
+		return nil
+	}
+	trailer := make([]byte, 48)
+	if _, err := r.ReadAt(trailer, size-48); err != nil {
+		return nil
+	}
+	trailer, err := rsDecode(rs16, trailer)
+	if valid, _ := regexp.Match(`^backup\d{10}$`, trailer); err != nil || !valid {
+		return nil
+	}
+	length, _ := strconv.ParseInt(string(trailer[6:]), 10, 64)
+	if length < 789 || 2*length+48 > size {
+		return nil
+	}
+	header := make([]byte, length)
+	if _, err := r.ReadAt(header, size-48-length); err != nil {
+		return nil
+	}
+	return header
+}
+
+// Read the chunks of a split volume as if they were one file
+type chunkedVolume struct {
+	path   string
+	chunks []*os.File
+	sizes  []int64
+	size   int64
+	pos    int64
+}
+
+func openChunks(path string) (*chunkedVolume, error) {
+	v := &chunkedVolume{path: path}
+	for i := 0; ; i++ {
+		fin, err := os.Open(fmt.Sprintf("%s.%d", path, i))
+		if err != nil {
+			break
+		}
+		stat, err := fin.Stat()
+		if err != nil {
+			fin.Close()
+			v.Close()
+			return nil, err
+		}
+		v.chunks = append(v.chunks, fin)
+		v.sizes = append(v.sizes, stat.Size())
+		v.size += stat.Size()
+	}
+	if len(v.chunks) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return v, nil
+}
+
+func (v *chunkedVolume) ReadAt(data []byte, off int64) (int, error) {
+	read := 0
+	for i, chunk := range v.chunks {
+		if read == len(data) {
+			break
+		}
+		if off >= v.sizes[i] {
+			off -= v.sizes[i]
+			continue
+		}
+		want := int(min(int64(len(data)-read), v.sizes[i]-off))
+		n, err := chunk.ReadAt(data[read:read+want], off)
+		read += n
+		if n != want {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+		off = 0
+	}
+	if read != len(data) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (v *chunkedVolume) Read(data []byte) (int, error) {
+	n, err := v.ReadAt(data, v.pos)
+	v.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (v *chunkedVolume) Seek(offset int64, whence int) (int64, error) {
+	v.pos = seekPos(v.pos, v.size, offset, whence)
+	return v.pos, nil
+}
+
+func (v *chunkedVolume) Close() error {
+	for _, chunk := range v.chunks {
+		chunk.Close()
+	}
+	return nil
+}
+
+// Volumes can be written to and read from S3-compatible object storage, named s3://bucket/key,
+// and from SFTP servers, named sftp://user@host:port/path
+func isRemote(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "sftp://")
+}
+
+// An S3-compatible server, configured by the environment
+type s3Client struct {
+	endpoint *url.URL
+	region   string
+	id       string
+	secret   string
+	token    string // The session token of temporary credentials
+}
+
+// How long a connection to S3 can go without sending or receiving anything before it fails
+const s3Timeout = time.Minute
+
+// The HTTP client of every S3 request, which gives up on a stalled connection instead of
+// waiting forever, whether it stalls while connecting, uploading, or downloading
+var s3HTTP = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{Timeout: s3Timeout, KeepAlive: 30 * time.Second}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return idleConn{conn}, nil
+		},
+		TLSHandshakeTimeout:   s3Timeout,
+		ResponseHeaderTimeout: 5 * s3Timeout, // Servers can take a while to complete uploads
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	},
+}
+
+// A connection that fails once it has gone s3Timeout without any data moving
+type idleConn struct {
+	net.Conn
+}
+
+func (c idleConn) Read(data []byte) (int, error) {
+	c.SetDeadline(time.Now().Add(s3Timeout))
+	return c.Conn.Read(data)
+}
+
+func (c idleConn) Write(data []byte) (int, error) {
+	c.SetDeadline(time.Now().Add(s3Timeout))
+	return c.Conn.Write(data)
+}
+
+func newS3Client() (*s3Client, error) {
+	c := &s3Client{
+		region: os.Getenv("AWS_REGION"),
+		id:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.id == "" || c.secret == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	endpoint := os.Getenv("PICOCRYPT_S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("PICOCRYPT_S3_ENDPOINT must be an http or https URL")
+	}
+	c.endpoint = u
+	return c, nil
+}
+
+// Split s3://bucket/key into the bucket and the key
+func splitRemote(path string) (string, string, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(path, "s3://"), "/")
+	if bucket == "" || key == "" || strings.HasSuffix(key, "/") {
+		return "", "", errors.New(path + " isn't of the form s3://bucket/key")
+	}
+	return bucket, key, nil
+}
+
+// Percent-encode all but the unreserved characters (and slashes in paths), as signing expects
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("-._~", c) >= 0 || (path && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Send a signed request to the server
+// Responses other than 2xx are returned as errors, with the server's reason if it gave one
+func (c *s3Client) do(method string, bucket string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	uri := s3Escape(strings.TrimSuffix(c.endpoint.Path, "/")+"/"+bucket+"/"+key, true)
+	var params []string
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	sort.Strings(params)
+	req, err := http.NewRequest(method, c.endpoint.Scheme+"://"+c.endpoint.Host+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = strings.Join(params, "&")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.sign(req, uri, body, time.Now())
+
+	res, err := s3HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 64*KiB))
+		return nil, s3Error(data, res.Status)
+	}
+	return res, nil
+}
+
+// Sign a request with AWS Signature Version 4, covering the host and the headers set so far
+func (c *s3Client) sign(req *http.Request, uri string, body []byte, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+		values[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := sha256.Sum256([]byte(strings.Join([]string{
+		req.Method, uri, req.URL.RawQuery, headers.String(), signedHeaders, payloadHash,
+	}, "\n")))
+
+	scope := date[:8] + "/" + c.region + "/s3/aws4_request"
+	key := []byte("AWS4" + c.secret)
+	for _, i := range []string{date[:8], c.region, "s3", "aws4_request", "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(canonical[:])} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(i))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.id+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(key))
+}
+
+// The error in an S3 error response, or 'fallback' if there isn't one
+func s3Error(data []byte, fallback string) error {
+	var reason struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(data, &reason) == nil && reason.Code != "" {
+		return errors.New(reason.Code + ": " + reason.Message)
+	}
+	return errors.New(fallback)
+}
+
+// Reads an object with ranged requests, a few MiB at a time
+type s3Reader struct {
+	client *s3Client
+	bucket string
+	key    string
+	size   int64
+	pos    int64
+	lock   sync.Mutex
+	buffer []byte // The last range fetched, which starts at 'start'
+	start  int64
+}
+
+const s3ReadSize = 8 * MiB
+
+func openS3(path string) (*s3Reader, error) {
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	bucket, key, err := splitRemote(path)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.do("HEAD", bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return &s3Reader{client: client, bucket: bucket, key: key, size: res.ContentLength}, nil
+}
+
+func (r *s3Reader) ReadAt(data []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	read := 0
+	for read < len(data) && off < r.size {
+		if off < r.start || off >= r.start+int64(len(r.buffer)) {
+			end := min(off+max(s3ReadSize, int64(len(data)-read)), r.size) - 1
+			header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, end)}}
+			res, err := r.client.do("GET", r.bucket, r.key, nil, header, nil)
+			if err != nil {
+				return read, err
+			}
+			if res.StatusCode != http.StatusPartialContent {
+				res.Body.Close()
+				return read, errors.New("the server doesn't support ranged requests")
+			}
+			r.buffer, err = io.ReadAll(res.Body)
+			r.start = off
+			res.Body.Close()
+			if err == nil && len(r.buffer) == 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				r.buffer = nil
+				return read, err
+			}
+		}
+		n := copy(data[read:], r.buffer[off-r.start:])
+		read += n
+		off += int64(n)
+	}
+	if read < len(data) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (r *s3Reader) Read(data []byte) (int, error) {
+	n, err := r.ReadAt(data, r.pos)
+	r.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	r.pos = seekPos(r.pos, r.size, offset, whence)
+	return r.pos, nil
+}
+
+func (r *s3Reader) Close() error {
+	r.buffer = nil
+	return nil
+}
+
+// The os.FileInfo of an object or a split volume, so onDrop() and work() can treat it like a file
+type remoteInfo struct {
+	name string
+	size int64
+}
+
+func (i *remoteInfo) Name() string       { return i.name }
+func (i *remoteInfo) Size() int64        { return i.size }
+func (i *remoteInfo) Mode() os.FileMode  { return 0400 }
+func (i *remoteInfo) ModTime() time.Time { return time.Time{} }
+func (i *remoteInfo) IsDir() bool        { return false }
+func (i *remoteInfo) Sys() any           { return nil }
+
+// Stat a file opened by openInput, through its connection if it's remote instead of making another
+func statInput(r inputReader) (os.FileInfo, error) {
+	switch r := r.(type) {
+	case *sftpReader:
+		return r.File.Stat()
+	case *s3Reader:
+		return &remoteInfo{name: path.Base(r.key), size: r.size}, nil
+	case *chunkedVolume:
+		return &remoteInfo{name: filepath.Base(r.path), size: r.size}, nil
+	case *os.File:
+		return r.Stat()
+	}
+	return nil, errors.New("can't stat the input")
+}
+
+// Open a file, which may be remote
+func openInput(name string) (inputReader, error) {
+	if strings.HasPrefix(name, "sftp://") {
+		return openSFTP(name)
+	} else if isRemote(name) {
+		return openS3(name)
+	}
+	return os.Open(name)
+}
+
+// Start writing a volume to 'name' remotely, in parts of 'partSize' if it's on S3
+func createRemote(name string, partSize int64) (remoteWriter, error) {
+	if strings.HasPrefix(name, "sftp://") {
+		w, err := createSFTP(name)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	w, err := newS3Writer(name, partSize)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Uploads a volume as it's written, in parts if it's larger than one part
+// work() seeks back to finish the header, so the first part is only uploaded at the end
+type s3Writer struct {
+	client   *s3Client
+	bucket   string
+	key      string
+	partSize int
+	first    []byte // The first part, kept until finish()
+	part     []byte // The part being filled after it
+	size     int64
+	pos      int64
+	upload   string   // The ID of the multipart upload, once one is started
+	etags    []string // The ETags of the parts after the first
+	err      error    // Why uploading failed, which fails every later write too
+	finished bool
+}
+
+const s3PartSize = 16 * MiB
+
+// The most parts S3 allows in one upload
+const s3MaxParts = 10000
+
+// Get the part size for uploading a volume of 'size' bytes of contents, which stays at
+// s3PartSize unless the volume wouldn't fit in s3MaxParts parts of it. Two parts are kept
+// in memory, so this is only raised as much as it needs to be.
+func s3PartSizeFor(size int64) int64 {
+	if padding {
+		size += maxPaddingFor(size)
+	}
+	if reedsolo {
+		size = (size/MiB + 1) * (MiB/128*136 + 48)
+	} else {
+		size = (size/MiB + 1) * (MiB + 16)
+	}
+
+	// Leave room for the header and the rounding above
+	parts := int64(s3MaxParts - 100)
+	partSize := (size + parts - 1) / parts
+	partSize = (partSize + MiB - 1) / MiB * MiB
+	return max(partSize, s3PartSize)
+}
+
+// Start uploading to 'path' in parts of 'partSize', which is kept within what S3 allows
+func newS3Writer(path string, partSize int64) (*s3Writer, error) {
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	bucket, key, err := splitRemote(path)
+	if err != nil {
+		return nil, err
+	}
+	partSize = min(max(partSize, 5*MiB), 5*GiB)
+	return &s3Writer{client: client, bucket: bucket, key: key, partSize: int(partSize)}, nil
+}
+
+func (w *s3Writer) Write(data []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	// Rewriting the header
+	if w.pos < w.size {
+		if w.pos+int64(len(data)) > int64(len(w.first)) {
+			return 0, errors.New("only the first part can be rewritten")
+		}
+		copy(w.first[w.pos:], data)
+		w.pos += int64(len(data))
+		return len(data), nil
+	}
+
+	written := 0
+	for written < len(data) {
+		buffer := &w.part
+		if len(w.first) < w.partSize {
+			buffer = &w.first
+		}
+		n := min(len(data)-written, w.partSize-len(*buffer))
+		*buffer = append(*buffer, data[written:written+n]...)
+		written += n
+		w.size += int64(n)
+		w.pos = w.size
+		if len(w.part) == w.partSize {
+			if w.err = w.uploadPart(); w.err != nil {
+				return written, w.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Upload 'part' as the next part, starting the multipart upload first if needed
+func (w *s3Writer) uploadPart() error {
+	if w.upload == "" {
+		res, err := w.client.do("POST", w.bucket, w.key, url.Values{"uploads": {""}}, nil, nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadId string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil || result.UploadId == "" {
+			return errors.New("the server didn't start the upload")
+		}
+		w.upload = result.UploadId
+	}
+	if len(w.etags)+2 > s3MaxParts {
+		return errors.New("the volume is too large to upload in one object")
+	}
+	etag, err := w.putPart(len(w.etags)+2, w.part)
+	if err != nil {
+		return err
+	}
+	w.etags = append(w.etags, etag)
+	w.part = w.part[:0]
+	return nil
+}
+
+func (w *s3Writer) putPart(number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.upload}}
+	res, err := w.client.do("PUT", w.bucket, w.key, query, nil, data)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return res.Header.Get("ETag"), nil
+}
+
+// Upload what's left and complete the upload, which is when the object appears
+func (w *s3Writer) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.upload == "" && len(w.part) == 0 {
+		res, err := w.client.do("PUT", w.bucket, w.key, nil, nil, w.first)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		res.Body.Close()
+		w.finished = true
+		return nil
+	}
+	if len(w.part) > 0 {
+		if w.err = w.uploadPart(); w.err != nil {
+			return w.err
+		}
+	}
+	etag, err := w.putPart(1, w.first)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	var body bytes.Buffer
+	body.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range append([]string{etag}, w.etags...) {
+		fmt.Fprintf(&body, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, html.EscapeString(etag))
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+	res, err := w.client.do("POST", w.bucket, w.key, url.Values{"uploadId": {w.upload}}, nil, body.Bytes())
+	if err != nil {
+		w.err = err
+		return err
+	}
+
+	// Completing can still fail after the server has responded with 200
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err == nil && bytes.Contains(data, []byte("<Error>")) {
+		err = s3Error(data, "the server didn't complete the upload")
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.finished = true
+	return nil
+}
+
+func (w *s3Writer) failed() error {
+	return w.err
+}
+
+func (w *s3Writer) ReadAt(data []byte, off int64) (int, error) {
+	if off+int64(len(data)) > int64(len(w.first)) {
+		return 0, errors.New("only the first part can be read back")
+	}
+	return copy(data, w.first[off:]), nil
+}
+
+func (w *s3Writer) Seek(offset int64, whence int) (int64, error) {
+	w.pos = seekPos(w.pos, w.size, offset, whence)
+	return w.pos, nil
+}
+
+func (w *s3Writer) Name() string {
+	return "s3://" + w.bucket + "/" + w.key
+}
+
+// Abort the upload unless it was finished, so the server doesn't keep the parts around
+func (w *s3Writer) Close() error {
+	if w.upload != "" && !w.finished {
+		res, err := w.client.do("DELETE", w.bucket, w.key, url.Values{"uploadId": {w.upload}}, nil, nil)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+	w.upload, w.first, w.part = "", nil, nil
+	return nil
+}
+
+// A connection to an SFTP server, and the path on it that an sftp:// URL names
+type sftpSession struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	path   string
+}
+
+// Connect to the server in an sftp:// URL, authenticating with keys only
+// Its host key must be in PICOCRYPT_KNOWN_HOSTS, which is ~/.ssh/known_hosts by default. The keys
+// are those in ssh-agent, and PICOCRYPT_SSH_KEY or else ~/.ssh/id_ed25519, id_ecdsa, and id_rsa.
+func dialSFTP(name string) (*sftpSession, error) {
+	u, err := url.Parse(name)
+	if err != nil || u.Hostname() == "" || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, errors.New(name + " isn't of the form sftp://user@host:port/path")
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	home, _ := os.UserHomeDir()
+
+	// Only connect to servers that are already known
+	knownHosts := os.Getenv("PICOCRYPT_KNOWN_HOSTS")
+	if knownHosts == "" {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, errors.New("can't read known hosts (" + err.Error() + ")")
+	}
+
+	var signers []ssh.Signer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			defer conn.Close()
+			if keys, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, keys...)
+			}
+		}
+	}
+	keys := []string{os.Getenv("PICOCRYPT_SSH_KEY")}
+	if keys[0] == "" {
+		keys = nil
+		for _, i := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			keys = append(keys, filepath.Join(home, ".ssh", i))
+		}
+	}
+	for _, i := range keys {
+		data, err := os.ReadFile(i)
+		if err == nil {
+			var signer ssh.Signer
+			if signer, err = ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, signer)
+				continue
+			}
+		}
+		if os.Getenv("PICOCRYPT_SSH_KEY") != "" { // Only the default keys are optional
+			return nil, errors.New("can't read " + i + " (" + err.Error() + ")")
+		}
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no SSH keys found")
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpSession{conn: conn, client: client, path: u.Path}, nil
+}
+
+func (s *sftpSession) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
+
+// A file read from an SFTP server, which hangs up when it's closed
+type sftpReader struct {
+	*sftp.File
+	session *sftpSession
+}
+
+func openSFTP(name string) (*sftpReader, error) {
+	session, err := dialSFTP(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := session.client.Open(session.path)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &sftpReader{File: file, session: session}, nil
+}
+
+// Like os.File, only return io.EOF once there's nothing left, unlike sftp.File
+func (r *sftpReader) Read(data []byte) (int, error) {
+	n, err := r.File.Read(data)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *sftpReader) Close() error {
+	r.File.Close()
+	return r.session.Close()
+}
+
+// Writes a volume to an SFTP server as a .incomplete file, which is renamed once it's finished
+type sftpWriter struct {
+	*sftp.File
+	session  *sftpSession
+	name     string
+	err      error
+	finished bool
+}
+
+func createSFTP(name string) (*sftpWriter, error) {
+	session, err := dialSFTP(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := session.client.OpenFile(session.path+".incomplete", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &sftpWriter{File: file, session: session, name: name}, nil
+}
+
+func (w *sftpWriter) Write(data []byte) (int, error) {
+	n, err := w.File.Write(data)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *sftpWriter) Name() string {
+	return w.name + ".incomplete"
+}
+
+func (w *sftpWriter) failed() error {
+	return w.err
+}
+
+// Close the file and rename it to the volume's name, replacing anything already there
+func (w *sftpWriter) finish() error {
+	err := w.File.Close()
+	if err == nil {
+		incomplete := w.session.path + ".incomplete"
+		if err = w.session.client.PosixRename(incomplete, w.session.path); err != nil {
+			err = w.session.client.Rename(incomplete, w.session.path) // Without the OpenSSH extension
+		}
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.finished = true
+	return nil
+}
+
+// Hang up, removing the .incomplete file unless the volume was finished
+func (w *sftpWriter) Close() error {
+	if !w.finished {
+		w.File.Close()
+		w.session.client.Remove(w.session.path + ".incomplete")
+	}
+	return w.session.Close()
+}
+
+// Generate a cryptographically secure password
+func genPassword() string {
+	chars := ""
+	if passgenUpper {
+		chars += "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	}
+	if passgenLower {
+		chars += "abcdefghijklmnopqrstuvwxyz"
+	}
+	if passgenNums {
+		chars += "1234567890"
+	}
+	if passgenSymbols {
+		chars += "-=_+!@#$^&()?<>"
+	}
+	tmp := make([]byte, passgenLength)
+	for i := range int(passgenLength) {
+		j, _ := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		tmp[i] = chars[j.Int64()]
+	}
+	if passgenCopy {
+		giu.Context.GetPlatform().SetClipboard(string(tmp))
+	}
+	return string(tmp)
+}
+
+// Convert done, total, and starting time to progress, speed, and ETA
+func statify(done int64, total int64, start time.Time) (float32, float64, string) {
+	progress := float32(done) / float32(total)
+	elapsed := float64(time.Since(start)) / float64(MiB) / 1000
+	speed := float64(done) / elapsed / float64(MiB)
+	eta := int(math.Floor(float64(total-done) / (speed * float64(MiB))))
 	return float32(math.Min(float64(progress), 1)), speed, timeify(eta)
 }
 
-// Convert seconds to HH:MM:SS
-func timeify(seconds int) string {
-	hours := int(math.Floor(float64(seconds) / 3600))
-	seconds %= 3600
-	minutes := int(math.Floor(float64(seconds) / 60))
-	seconds %= 60
-	hours = int(math.Max(float64(hours), 0))
-	minutes = int(math.Max(float64(minutes), 0))
-	seconds = int(math.Max(float64(seconds), 0))
-	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+// Convert seconds to HH:MM:SS
+func timeify(seconds int) string {
+	hours := int(math.Floor(float64(seconds) / 3600))
+	seconds %= 3600
+	minutes := int(math.Floor(float64(seconds) / 60))
+	seconds %= 60
+	hours = int(math.Max(float64(hours), 0))
+	minutes = int(math.Max(float64(minutes), 0))
+	seconds = int(math.Max(float64(seconds), 0))
+	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+}
+
+// Convert bytes to KiB, MiB, etc.
+func sizeify(size int64) string {
+	if size >= int64(TiB) {
+		return fmt.Sprintf("%.2f TiB", float64(size)/float64(TiB))
+	} else if size >= int64(GiB) {
+		return fmt.Sprintf("%.2f GiB", float64(size)/float64(GiB))
+	} else if size >= int64(MiB) {
+		return fmt.Sprintf("%.2f MiB", float64(size)/float64(MiB))
+	} else {
+		return fmt.Sprintf("%.2f KiB", float64(size)/float64(KiB))
+	}
+}
+
+func unpackArchive(zipPath string) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var totalSize int64
+	for _, f := range reader.File {
+		totalSize += int64(f.UncompressedSize64)
+	}
+
+	var extractDir string
+	if sameLevel {
+		extractDir = filepath.Dir(zipPath)
+	} else {
+		extractDir = filepath.Join(filepath.Dir(zipPath), strings.TrimSuffix(filepath.Base(zipPath), ".zip"))
+	}
+
+	var done int64
+	startTime := time.Now()
+
+	for _, f := range reader.File {
+		if strings.Contains(f.Name, "..") {
+			return errors.New("potentially malicious zip item path")
+		}
+		outPath := filepath.Join(extractDir, f.Name)
+
+		// Make directory if current entry is a folder
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(outPath, 0700); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, f := range reader.File {
+		if strings.Contains(f.Name, "..") {
+			return errors.New("potentially malicious zip item path")
+		}
+
+		// Already handled above
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		outPath := filepath.Join(extractDir, f.Name)
+
+		// Otherwise create necessary parent directories
+		if err := os.MkdirAll(filepath.Dir(outPath), 0700); err != nil {
+			return err
+		}
+
+		// Open the file inside the archive
+		fileInArchive, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer fileInArchive.Close()
+
+		dstFile, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+
+		// Read from zip in chunks to update progress
+		buffer := make([]byte, MiB)
+		for {
+			n, readErr := fileInArchive.Read(buffer)
+			if n > 0 {
+				_, writeErr := dstFile.Write(buffer[:n])
+				if writeErr != nil {
+					dstFile.Close()
+					os.Remove(dstFile.Name())
+					return writeErr
+				}
+
+				done += int64(n)
+				progress, speed, eta = statify(done, totalSize, startTime)
+				progressInfo = fmt.Sprintf("%d/%d", i+1, len(reader.File))
+				popupStatus = fmt.Sprintf("Unpacking at %.2f MiB/s (ETA: %s)", speed, eta)
+				giu.Update()
+			}
+			if readErr != nil {
+				if readErr == io.EOF {
+					break
+				}
+				dstFile.Close()
+				return readErr
+			}
+		}
+		dstFile.Close()
+	}
+
+	return nil
+}
+
+// The name of the encrypted list of random names that recursive batches give volumes
+const indexName = "picocrypt-index"
+
+// What happened to one file of a recursive batch
+type batchResult struct {
+	File   string `json:"file"`
+	Mode   string `json:"mode"`   // "encrypt" or "decrypt"
+	Result string `json:"result"` // "success", "wrong password", "corrupted", "skipped", or "failed"
+	Status string `json:"status"` // The status shown for the file, like "The volume is damaged or modified"
+}
+
+// Encrypt or decrypt each file on its own, carrying on past failures, and record the results
+// 'setup' sets the options after each drop, as dropping resets them
+// Returns false if the user cancelled the batch
+func workBatch(files []string, setup func()) bool {
+	batchKeys = make(map[string][]byte)
+	batchResults = nil
+	working = true // Cleared by cancelling, even between files
+	defer endBatch()
+
+	// Dropping resets these, so keep them for the whole batch
+	root, random := outputRoot, randomNames && outputRoot != ""
+	rel := make(map[string]string) // Paths from the dropped folders, which are mirrored into 'root'
+	for _, file := range files {
+		rel[file] = filepath.Base(file)
+		for _, folder := range onlyFolders {
+			if strings.HasPrefix(file, folder+string(filepath.Separator)) {
+				rel[file], _ = filepath.Rel(filepath.Dir(folder), file)
+			}
+		}
+	}
+	names := make(map[string]string) // Real names of randomly named volumes, from their indexes
+	var index [][]string             // Random names given in this batch and the paths they stand for
+
+	// Drop a file and work on it, with 'output' choosing the output after the options are set
+	process := func(file string, output func()) (batchResult, bool) {
+		onDrop([]string{file})
+		result := batchResult{File: file, Mode: mode, Result: "skipped"}
+		if mainStatusColor == RED { // Like an unreadable file or a damaged header
+			result.Status = mainStatus
+			if mainOutcome != outcomeFailed {
+				result.Result = mainOutcome.String()
+			}
+			return result, true
+		}
+		setup()
+		output()
+		if !checkOptions() {
+			result.Status = mainStatus
+			if mainStatusColor != RED {
+				result.Status = "No password or keyfiles to use"
+			}
+			return result, true
+		} else if outputExists() {
+			result.Status = "Output already exists"
+			return result, true
+		}
+		if err := os.MkdirAll(filepath.Dir(outputFile), 0700); err != nil {
+			result.Result, result.Status = "failed", "Unable to create "+filepath.Dir(outputFile)
+			return result, true
+		}
+		fastDecode = true
+		work()
+		if !working {
+			return result, false
+		}
+		result.Status = mainStatus
+		result.Result = mainOutcome.String()
+		return result, true
+	}
+
+	// Read the indexes first so the volumes they list get their names back
+	var rest []string
+	for _, file := range files {
+		base := filepath.Base(file)
+		if !strings.HasPrefix(base, indexName) || !strings.HasSuffix(base, ".pcv") {
+			rest = append(rest, file)
+			continue
+		}
+		// Decrypt it next to itself rather than in the system's temporary folder
+		tmp, err := os.CreateTemp(filepath.Dir(file), indexName+"-*.csv")
+		if err != nil {
+			batchResults = append(batchResults, batchResult{
+				File: file, Mode: "decrypt", Result: "failed", Status: "Unable to write the index (" + err.Error() + ")",
+			})
+			continue
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+		defer os.Remove(tmp.Name())
+		result, ok := process(file, func() {
+			outputFile = tmp.Name()
+			delete = false
+			autoUnzip = false
+		})
+		if !ok {
+			return false
+		}
+		if result.Result == "success" {
+			if err := readIndex(tmp.Name(), filepath.Dir(file), names); err != nil {
+				result.Result, result.Status = "corrupted", "Unable to read the index ("+err.Error()+")"
+			}
+			os.Remove(tmp.Name())
+		}
+		batchResults = append(batchResults, result)
+	}
+
+	volumes := make(map[string]bool) // Each chunk of a split volume is listed
+	for _, file := range rest {
+		if i := strings.Index(file, ".pcv."); i != -1 {
+			if _, err := strconv.Atoi(file[i+5:]); err == nil {
+				if volumes[file[:i+4]] {
+					continue
+				}
+				volumes[file[:i+4]] = true
+			}
+		}
+
+		if !working {
+			return false
+		}
+		var output string
+		result, ok := process(file, func() {
+			name := filepath.Base(outputFile)
+			if real, ok := names[file]; ok && mode == "decrypt" {
+				name = real
+			} else if random && mode == "encrypt" {
+				tmp := make([]byte, 12)
+				if _, err := rand.Read(tmp); err != nil {
+					panic(err)
+				}
+				name = hex.EncodeToString(tmp) + ".pcv"
+			}
+			if root != "" {
+				outputFile = filepath.Join(root, filepath.Dir(rel[file]), name)
+			} else {
+				outputFile = filepath.Join(filepath.Dir(outputFile), name)
+			}
+			output = outputFile
+		})
+		if !ok {
+			return false
+		}
+		if random && result.Mode == "encrypt" && result.Result == "success" {
+			name, _ := filepath.Rel(root, output)
+			index = append(index, []string{filepath.ToSlash(name), filepath.ToSlash(rel[file])})
+		}
+		batchResults = append(batchResults, result)
+	}
+
+	// Encrypt the index of random names along with the files
+	if len(index) > 0 {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"name", "path"})
+		w.WriteAll(index)
+		path := filepath.Join(root, indexName+".pcv")
+		for i := 2; ; i++ {
+			if _, err := os.Stat(path); err != nil {
+				break
+			}
+			path = filepath.Join(root, fmt.Sprintf("%s-%d.pcv", indexName, i))
+		}
+
+		// Write it in the chosen folder rather than the system's temporary folder while it's encrypted
+		result := batchResult{File: path, Mode: "encrypt", Result: "failed"}
+		tmp, err := os.CreateTemp(root, indexName+"-*.csv")
+		if err == nil {
+			defer os.Remove(tmp.Name())
+			_, err = tmp.Write(buf.Bytes())
+			tmp.Close()
+		}
+		if err != nil {
+			result.Status = "Unable to write the index (" + err.Error() + ")"
+		} else {
+			var ok bool
+			result, ok = process(tmp.Name(), func() {
+				outputFile = path
+				split = false
+				delete = false
+			})
+			if !ok {
+				return false
+			}
+			result.File = path
+		}
+		batchResults = append(batchResults, result)
+	}
+	return true
+}
+
+// Read the random names of volumes in 'dir' and their real names from a decrypted index
+func readIndex(path string, dir string, names map[string]string) error {
+	fin, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	rows, err := csv.NewReader(fin).ReadAll()
+	if err != nil {
+		return err
+	} else if len(rows) == 0 {
+		return errors.New("no header")
+	}
+	for _, i := range rows[1:] {
+		if len(i) != 2 {
+			return errors.New("bad row")
+		}
+		names[filepath.Join(dir, filepath.FromSlash(i[0]))] = filepath.Base(filepath.FromSlash(i[1]))
+	}
+	return nil
+}
+
+// How an operation ended, which sorts the files of a batch
+type outcome int
+
+const (
+	outcomeFailed outcome = iota
+	outcomeSuccess
+	outcomeWrongPassword
+	outcomeCorrupted // Including volumes that were force decrypted
+)
+
+// The result of a batch for a file with this outcome
+func (o outcome) String() string {
+	return [...]string{"failed", "success", "wrong password", "corrupted"}[o]
+}
+
+// Count the results of the last batch, like "4 files: 3 succeeded, 1 wrong password"
+func batchSummary() (string, color.RGBA) {
+	counts := make(map[string]int)
+	for _, i := range batchResults {
+		counts[i.Result]++
+	}
+	summary := fmt.Sprintf("%d files:", len(batchResults))
+	if len(batchResults) == 1 {
+		summary = "1 file:"
+	}
+	var parts []string
+	for _, i := range [][2]string{
+		{"success", "succeeded"}, {"wrong password", "wrong password"},
+		{"corrupted", "corrupted"}, {"skipped", "skipped"}, {"failed", "failed"},
+	} {
+		if counts[i[0]] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[i[0]], i[1]))
+		}
+	}
+	if len(parts) == 0 {
+		return "No files to process", YELLOW
+	}
+	summary += " " + strings.Join(parts, ", ")
+	if counts["success"] == len(batchResults) {
+		return summary, GREEN
+	} else if counts["success"] == 0 {
+		return summary, RED
+	}
+	return summary, YELLOW
+}
+
+// Save the results of the last batch as JSON if 'path' ends with .json, or CSV otherwise
+func exportResults(path string) error {
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var err error
+		data, err = json.MarshalIndent(batchResults, "", "\t")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	} else {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"file", "mode", "result", "status"})
+		for _, i := range batchResults {
+			w.Write([]string{i.File, i.Mode, i.Result, i.Status})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Ask where to save the results of the last batch, starting with 'name'
+func saveResults(name string) {
+	f := dialog.File().Title("Choose where to save the results")
+	if len(batchResults) > 0 {
+		f.SetStartDir(filepath.Dir(batchResults[0].File))
+	}
+	f.SetInitFilename(name)
+	file, err := f.Save()
+	if file == "" || err != nil {
+		return
+	}
+	if err := exportResults(file); err != nil {
+		mainStatus = "Failed to save results"
+		mainStatusColor = RED
+	} else {
+		mainStatus = "Saved results to " + filepath.Base(file)
+		mainStatusColor = GREEN
+	}
+	giu.Update()
+}
+
+// A queued operation, run by another Picocrypt process so it doesn't touch the options being edited
+type job struct {
+	id       int
+	label    string    // What the job does, like "Encrypt: report.pdf"
+	spec     []byte    // The input, options, and secrets for 'picocrypt job', as a line of JSON
+	state    string    // "Queued", "Paused", "Running", "Done", "Failed", or "Cancelled"
+	status   string    // The last status of the job
+	progress float32   // Progress of the job while it's running
+	stdin    io.Closer // Closing this cancels the job while it's running
+}
+
+// A job as it's given to 'picocrypt job' on standard input
+type jobSpec struct {
+	Mode       string       `json:"mode"`
+	Inputs     []string     `json:"inputs"`
+	Output     string       `json:"output,omitempty"`
+	Options    []string     `json:"options,omitempty"`
+	Split      string       `json:"split,omitempty"`
+	Pad        string       `json:"pad,omitempty"`
+	Shares     string       `json:"shares,omitempty"` // Like "2/3"
+	Keyfiles   []string     `json:"keyfiles,omitempty"`
+	Comments   string       `json:"comments,omitempty"`
+	Recipients []*pem.Block `json:"recipients,omitempty"` // Or identities when decrypting
+	SigningKey []byte       `json:"signingKey,omitempty"` // The Ed25519 seed
+	Password   string       `json:"password,omitempty"`
+}
+
+// Describe the current input and options as a job
+func newJob() *job {
+	var options []string
+	for _, i := range []struct {
+		name string
+		on   bool
+	}{
+		{"paranoid", paranoid}, {"reed-solomon", reedsolo}, {"backup-header", backupHeader}, {"compress", compress},
+		{"deniability", deniability}, {"recursively", recursively}, {"ordered", keyfileOrdered}, {"delete", delete},
+		{"force", keep}, {"auto-unzip", autoUnzip}, {"same-level", sameLevel}, {"random-names", randomNames},
+	} {
+		if i.on {
+			options = append(options, i.name)
+		}
+	}
+	spec := jobSpec{
+		Mode:       mode,
+		Options:    options,
+		Split:      formatAmount(split, splitSize, splitUnits, splitSelected),
+		Pad:        formatAmount(padding, padSize, padUnits, padSelected),
+		Keyfiles:   keyfiles,
+		Recipients: recipients,
+		Password:   password,
+	}
+	if !recursively {
+		spec.Output = outputFile
+	} else if outputRoot != "" { // The folder that the outputs are saved in
+		spec.Output = outputRoot
+	}
+	if mode == "encrypt" && shares {
+		spec.Shares = fmt.Sprintf("%d/%d", sharesNeeded, sharesTotal)
+	}
+	if mode == "encrypt" {
+		spec.Comments = comments
+	}
+	if mode == "encrypt" && sign {
+		spec.SigningKey = signingKey.Seed()
+	}
+
+	// A split volume is found again from its first chunk
+	inputs := append(append([]string{}, onlyFiles...), onlyFolders...)
+	if recombine {
+		inputs = []string{inputFile + ".0"}
+	}
+	spec.Inputs = inputs
+	data, err := json.Marshal(spec)
+	if err != nil {
+		panic(err)
+	}
+	name := inputLabel
+	if len(inputs) == 1 {
+		name = filepath.Base(inputs[0])
+	}
+	label := startLabel
+	if recursively {
+		label = "Process"
+	}
+	jobId++
+	return &job{id: jobId, label: label + ": " + name, spec: append(data, '\n'), state: "Queued", status: "Waiting"}
+}
+
+// Add the current input and options to the queue and start running it if it isn't already
+func addJob() {
+	if !checkOptions() {
+		return
+	}
+	if hiddenVolume {
+		mainStatus = "Hidden volumes can't be queued"
+		mainStatusColor = RED
+		giu.Update()
+		return
+	}
+	if _, err := os.Stat(outputFile); err == nil && !recursively {
+		mainStatus = "Output already exists"
+		mainStatusColor = RED
+		giu.Update()
+		return
+	}
+	next := newJob()
+	resetUI()
+	mainStatus = "Added to the queue"
+	mainStatusColor = GREEN
+	jobsLock.Lock()
+	jobs = append(jobs, next)
+	if !jobsRunning {
+		jobsRunning = true
+		go runJobs()
+	}
+	jobsLock.Unlock()
+	giu.Update()
+}
+
+// Run the queued jobs in order, one at a time, until there are none left
+func runJobs() {
+	for {
+		jobsLock.Lock()
+		var next *job
+		for _, i := range jobs {
+			if i.state == "Queued" {
+				next = i
+				break
+			}
+		}
+		if next == nil {
+			jobsRunning = false
+			jobsLock.Unlock()
+			giu.Update()
+			return
+		}
+		next.state = "Running"
+		next.status = "Starting..."
+		jobsLock.Unlock()
+		giu.Update()
+
+		state, status := next.run()
+		jobsLock.Lock()
+		next.state, next.status = state, status
+		next.stdin = nil
+		jobsLock.Unlock()
+		giu.Update()
+	}
+}
+
+// Run a job in a new Picocrypt process and return its final state and status
+func (j *job) run() (string, string) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "Failed", err.Error()
+	}
+	cmd := exec.Command(exe, "job")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		panic(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		panic(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return "Failed", err.Error()
+	}
+	jobsLock.Lock()
+	j.stdin = stdin
+	cancelled := j.state == "Cancelled"
+	jobsLock.Unlock()
+	if cancelled {
+		stdin.Close()
+	} else if _, err := stdin.Write(j.spec); err != nil {
+		stdin.Close()
+	}
+
+	// Each line is either "progress <fraction> <status>" or the final status
+	status := "Failed to start"
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "progress "); ok {
+			fraction, message, _ := strings.Cut(rest, " ")
+			tmp, err := strconv.ParseFloat(fraction, 32)
+			if err != nil {
+				continue
+			}
+			jobsLock.Lock()
+			j.progress, j.status = float32(tmp), message
+			jobsLock.Unlock()
+			giu.Update()
+		} else {
+			status = line
+		}
+	}
+	err = cmd.Wait()
+	stdin.Close()
+
+	jobsLock.Lock()
+	cancelled = j.state == "Cancelled"
+	jobsLock.Unlock()
+	if cancelled {
+		return "Cancelled", "Operation cancelled by user"
+	} else if err != nil {
+		return "Failed", status
+	}
+	return "Done", status
+}
+
+// Run a job written by newJob, reporting progress and the final status on 'out'
+func runJob(in io.Reader, out io.Writer) int {
+	// The job is the first line, and closing 'in' after it cancels the job
+	reader := bufio.NewReader(in)
+	line, err := reader.ReadBytes('\n')
+	var spec jobSpec
+	if err != nil || json.Unmarshal(line, &spec) != nil || len(spec.Inputs) == 0 {
+		fmt.Fprintln(out, "Incomplete job")
+		return 2
+	}
+	options := make(map[string]bool)
+	for _, i := range spec.Options {
+		options[i] = true
+	}
+
+	// Set the options after each drop, as dropping resets them
+	setup := func() {
+		password, cpassword = spec.Password, spec.Password
+		if mode != "decrypt" || keyfile || deniability {
+			keyfiles = spec.Keyfiles
+		}
+		if mode != "decrypt" || deniability {
+			keyfileOrdered = options["ordered"]
+		}
+		recipients = spec.Recipients
+		if mode == "encrypt" {
+			comments = spec.Comments
+			paranoid = options["paranoid"]
+			reedsolo = options["reed-solomon"]
+			backupHeader = options["backup-header"]
+			compress = options["compress"]
+			deniability = options["deniability"]
+			split, splitSize, splitSelected = parseAmount(spec.Split, splitUnits)
+			padding, padSize, padSelected = parseAmount(spec.Pad, padUnits)
+			if n, _ := fmt.Sscanf(spec.Shares, "%d/%d", &sharesNeeded, &sharesTotal); n == 2 {
+				shares = true
+			}
+			if len(spec.SigningKey) == ed25519.SeedSize {
+				signingKey = ed25519.NewKeyFromSeed(spec.SigningKey)
+				sign = true
+			}
+		}
+		delete = options["delete"]
+		keep = options["force"]
+		autoUnzip = options["auto-unzip"]
+		sameLevel = options["same-level"]
+	}
+
+	onDrop(spec.Inputs)
+	for scanning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mainStatusColor == RED {
+		fmt.Fprintln(out, mainStatus)
+		return 1
+	}
+	if mode != spec.Mode {
+		fmt.Fprintln(out, "Can't "+spec.Mode+" "+inputLabel)
+		return 2
+	}
+	if _, err := os.Stat(spec.Output); err == nil && !options["recursively"] {
+		fmt.Fprintln(out, "Please remove "+filepath.Base(spec.Output))
+		return 1
+	}
+
+	// Report progress until the job is done, and cancel it if 'in' is closed before then
+	cancelled := false
+	done := make(chan bool)
+	reported := make(chan bool)
+	go func() {
+		io.Copy(io.Discard, reader)
+		select {
+		case <-done:
+		default:
+			cancelled = true
+			working = false
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-done:
+				reported <- true
+				return
+			case <-time.After(250 * time.Millisecond):
+				fmt.Fprintf(out, "progress %f %s\n", progress, popupStatus)
+			}
+		}
+	}()
+
+	if options["recursively"] {
+		outputRoot, randomNames = spec.Output, options["random-names"]
+		if workBatch(allFiles, setup) {
+			resetUI()
+			mainStatus, mainStatusColor = batchSummary()
+		}
+	} else {
+		outputFile = spec.Output
+		setup()
+		if !cancelled {
+			fastDecode = true
+			work()
+		}
+	}
+	working = false
+	close(done)
+	<-reported
+	if cancelled {
+		fmt.Fprintln(out, "Operation cancelled by user")
+		return 1
+	}
+	fmt.Fprintln(out, mainStatus)
+	if mainStatusColor == RED {
+		return 1
+	}
+	return 0
+}
+
+// Encrypt the files that appear in 'folders' once they've stopped changing for 'settle', until
+// 'stop' is closed. Outputs are saved in 'output' at the same path relative to their folder,
+// or next to each file if 'output' is empty.
+func watchFolders(folders []string, output string, settle time.Duration, setup func(), logger *log.Logger, stop <-chan struct{}) error {
+	var err error
+	for i := range folders {
+		if folders[i], err = filepath.Abs(folders[i]); err != nil {
+			return err
+		}
+		if stat, err := os.Stat(folders[i]); err != nil || !stat.IsDir() {
+			return errors.New(folders[i] + " isn't a folder")
+		}
+	}
+	if output != "" {
+		if output, err = filepath.Abs(output); err != nil {
+			return err
+		}
+	}
+
+	// Without inotify, look at the folders every second
+	var events chan struct{}
+	watcher, err := newFolderWatcher()
+	if err == nil {
+		defer watcher.Close()
+		events = watcher.events
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	type seen struct {
+		size     int64
+		modified time.Time
+		since    time.Time // When the size or time last changed
+		done     bool      // Tried already, and not tried again unless it changes
+	}
+	files := make(map[string]*seen)
+	for {
+		pending := false
+		found := make(map[string]*seen)
+		for _, folder := range folders {
+			filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+				if err != nil { // Files can disappear while they're looked at
+					return nil
+				}
+				if info.IsDir() {
+					if path == output {
+						return filepath.SkipDir
+					}
+					if watcher != nil {
+						if err := watcher.add(path); err != nil {
+							logger.Printf("Unable to watch %s: %s", path, err)
+						}
+					}
+					return nil
+				}
+				name := info.Name()
+				if strings.HasSuffix(name, ".pcv") || strings.Contains(name, ".pcv.") ||
+					strings.HasSuffix(name, ".incomplete") || strings.HasSuffix(name, ".tmp") {
+					return nil
+				}
+
+				f, ok := files[path]
+				if !ok || f.size != info.Size() || !f.modified.Equal(info.ModTime()) {
+					f = &seen{size: info.Size(), modified: info.ModTime(), since: time.Now()}
+				}
+				found[path] = f
+				if f.done {
+					return nil
+				} else if time.Since(f.since) < settle {
+					pending = true
+					return nil
+				}
+				select {
+				case <-stop:
+					return filepath.SkipAll
+				default:
+				}
+				f.done = true
+				out := ""
+				if output != "" {
+					rel, _ := filepath.Rel(folder, path)
+					out = filepath.Join(output, rel)
+				}
+				encryptWatched(path, out, setup, logger)
+				return nil
+			})
+		}
+		files = found
+
+		// Check again soon if a file is still settling, otherwise wait for a change
+		var tick <-chan time.Time
+		if pending || watcher == nil {
+			tick = ticker.C
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-tick:
+		case _, ok := <-events:
+			if !ok {
+				return errors.New("stopped receiving changes")
+			}
+		}
+	}
+}
+
+// Encrypt a file found by watchFolders() to 'out' (with ".pcv" added), or next to it if 'out' is empty
+func encryptWatched(path string, out string, setup func(), logger *log.Logger) {
+	onDrop([]string{path})
+	if mainStatusColor == RED {
+		logger.Printf("Skipped %s: %s", path, mainStatus)
+		return
+	}
+	setup()
+	if out != "" {
+		outputFile = out + ".pcv"
+	}
+	output, deleting := outputFile, delete
+	if outputExists() {
+		logger.Printf("Skipped %s: %s already exists", path, output)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0700); err != nil {
+		logger.Printf("Skipped %s: %s", path, err)
+		return
+	}
+	work()
+	if !working {
+		logger.Printf("Cancelled encrypting %s", path)
+		return
+	}
+	working = false
+	if mainStatusColor == RED {
+		logger.Printf("Failed to encrypt %s: %s", path, mainStatus)
+	} else if deleting {
+		logger.Printf("Encrypted %s to %s and deleted it", path, output)
+	} else {
+		logger.Printf("Encrypted %s to %s", path, output)
+	}
+}
+
+// A volume opened for reading its files on demand
+type openedVolume struct {
+	file interface {
+		io.ReaderAt
+		io.Closer
+	}
+	size    int64
+	archive *zip.Reader // nil if the volume holds a single file
+	name    string      // The name of that single file
+}
+
+// Open 'volume' for reading, with 'setup' setting the options after the drop. Volumes with per-block
+// MACs are read in place and decrypted a block at a time as they're read. Older and signed ones are
+// decrypted into a sealed temporary file first, since only the MAC of the whole volume covers them,
+// which takes as long as decrypting them normally and as much space in the temporary folder.
+func openVolume(volume string, setup func()) (*openedVolume, error) {
+	onDrop([]string{volume})
+	for scanning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mainStatusColor == RED {
+		return nil, errors.New(mainStatus)
+	} else if mode != "decrypt" {
+		return nil, errors.New(volume + " isn't a volume")
+	}
+	setup()
+	name := filepath.Base(strings.TrimSuffix(outputFile, ".zip"))
+	tmp, err := os.CreateTemp("", "picocrypt-mount-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	sealKey = make([]byte, 32)
+	if _, err := rand.Read(sealKey); err != nil {
+		panic(err)
+	}
+	key := sealKey
+	defer func() {
+		sealKey = nil
+		openedPayload = nil
+	}()
+	outputFile = tmp.Name()
+	fastDecode = true
+	work()
+	working = false
+	if mainStatusColor == RED {
+		return nil, errors.New(mainStatus)
+	}
+
+	var v *openedVolume
+	if openedPayload != nil {
+		v = &openedVolume{file: openedPayload, size: openedPayload.size, name: name}
+	} else {
+		// Only the open file keeps the data around, so nothing is left behind after unmounting
+		fin, err := os.Open(tmp.Name())
+		os.Remove(tmp.Name())
+		if err != nil {
+			return nil, err
+		}
+		stat, err := fin.Stat()
+		if err != nil {
+			fin.Close()
+			return nil, err
+		}
+		v = &openedVolume{file: &sealedFile{file: fin, key: key}, size: stat.Size(), name: name}
+	}
+	if archive, err := zip.NewReader(v.file, v.size); err == nil {
+		v.archive = archive
+	}
+	return v, nil
+}
+
+// Reads a file in an opened volume from any offset. Stored files are read straight from where
+// they are, while compressed ones are decompressed as they're read, starting over only if
+// they're read backwards.
+type entryReader struct {
+	volume *openedVolume
+	file   *zip.File // nil for the volume's only file if it isn't a zip
+	size   int64
+	lock   sync.Mutex
+	r      io.ReadCloser // Decompresses the file, and is at 'pos'
+	pos    int64
+	offset int64 // Where Read() reads from next
+}
+
+func newEntryReader(volume *openedVolume, file *zip.File) *entryReader {
+	if file == nil {
+		return &entryReader{volume: volume, size: volume.size}
+	}
+	return &entryReader{volume: volume, file: file, size: int64(file.UncompressedSize64)}
+}
+
+func (e *entryReader) ReadAt(data []byte, off int64) (int, error) {
+	if off >= e.size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(data)), e.size-off)
+	if e.file == nil || e.file.Method == zip.Store {
+		start := int64(0)
+		if e.file != nil {
+			var err error
+			if start, err = e.file.DataOffset(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := e.volume.file.ReadAt(data[:want], start+off)
+		if err == nil && want < int64(len(data)) {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.r == nil || off < e.pos {
+		if e.r != nil {
+			e.r.Close()
+		}
+		r, err := e.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		e.r, e.pos = r, 0
+	}
+	if _, err := io.CopyN(io.Discard, e.r, off-e.pos); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(e.r, data[:want])
+	e.pos = off + int64(n)
+	if err == nil && want < int64(len(data)) {
+		err = io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (e *entryReader) Read(data []byte) (int, error) {
+	n, err := e.ReadAt(data, e.offset)
+	e.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (e *entryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += e.offset
+	case io.SeekEnd:
+		offset += e.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	e.offset = offset
+	return offset, nil
+}
+
+func (e *entryReader) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.r != nil {
+		return e.r.Close()
+	}
+	return nil
+}
+
+// A file or folder served from opened volumes
+type servedNode struct {
+	name     string
+	volume   *openedVolume
+	file     *zip.File              // nil for folders and for a volume's only file
+	children map[string]*servedNode // nil for files
+	modified time.Time
+	open     func() (*openedVolume, error) // Opens the volume of a volume's folder when it's first used
+	once     sync.Once
+	err      error // Why the volume couldn't be opened
+}
+
+// A volume to serve, which isn't opened until its files are needed
+type servedVolume struct {
+	name string // The volume's file name, which its folder is named after
+	open func() (*openedVolume, error)
+}
+
+func (n *servedNode) Name() string {
+	return n.name
+}
+
+func (n *servedNode) Size() int64 {
+	if n.IsDir() {
+		return 0
+	} else if n.file == nil {
+		return n.volume.size
+	}
+	return int64(n.file.UncompressedSize64)
+}
+
+func (n *servedNode) Mode() os.FileMode {
+	if n.IsDir() {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (n *servedNode) ModTime() time.Time {
+	return n.modified
+}
+
+func (n *servedNode) IsDir() bool {
+	return n.children != nil
+}
+
+func (n *servedNode) Sys() any {
+	return nil
+}
+
+// The names of a folder's children in order
+func (n *servedNode) names() []string {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open the volume of a volume's folder if it hasn't been yet, and add its files to the folder
+func (n *servedNode) load() error {
+	if n.open != nil {
+		n.once.Do(func() {
+			volume, err := n.open()
+			if err != nil {
+				n.err = err
+				return
+			}
+			n.add(volume)
+		})
+	}
+	return n.err
+}
+
+// Add the files of 'volume' to the folder, as if it was unzipped into it
+func (n *servedNode) add(volume *openedVolume) {
+	if volume.archive == nil {
+		n.children[volume.name] = &servedNode{name: volume.name, volume: volume}
+		return
+	}
+	for _, f := range volume.archive.File {
+		if strings.Contains(f.Name, "..") {
+			continue
+		}
+		dir := n
+		parts := strings.Split(strings.Trim(f.Name, "/"), "/")
+		for i, part := range parts {
+			if i == len(parts)-1 && !f.FileInfo().IsDir() {
+				dir.children[part] = &servedNode{name: part, volume: volume, file: f, modified: f.Modified}
+				break
+			}
+			child, ok := dir.children[part]
+			if !ok || !child.IsDir() {
+				child = &servedNode{name: part, children: make(map[string]*servedNode), modified: f.Modified}
+				dir.children[part] = child
+			}
+			dir = child
+		}
+	}
+}
+
+// The files of opened volumes as a read-only webdav.FileSystem
+type servedFS struct {
+	root *servedNode
+}
+
+func (s *servedFS) find(name string) (*servedNode, error) {
+	n := s.root
+	for _, part := range strings.Split(path.Clean("/"+name), "/") {
+		if part == "" {
+			continue
+		}
+		if err := n.load(); err != nil {
+			return nil, err
+		}
+		child, ok := n.children[part]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		n = child
+	}
+	return n, n.load()
+}
+
+func (s *servedFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (s *servedFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	n, err := s.find(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &servedFile{node: n}
+	if !n.IsDir() {
+		f.r = newEntryReader(n.volume, n.file)
+	}
+	return f, nil
+}
+
+func (s *servedFS) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (s *servedFS) Rename(ctx context.Context, oldName string, newName string) error {
+	return os.ErrPermission
+}
+
+func (s *servedFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return s.find(name)
+}
+
+// An open file or folder of a servedFS
+type servedFile struct {
+	node *servedNode
+	r    *entryReader // nil for folders
+	read int          // How many children Readdir() has returned
+}
+
+func (f *servedFile) Read(data []byte) (int, error) {
+	if f.r == nil {
+		return 0, errors.New("is a folder")
+	}
+	return f.r.Read(data)
+}
+
+func (f *servedFile) Seek(offset int64, whence int) (int64, error) {
+	if f.r == nil {
+		return 0, errors.New("is a folder")
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *servedFile) Readdir(count int) ([]os.FileInfo, error) {
+	names := f.node.names()[f.read:]
+	if count > 0 && len(names) == 0 {
+		return nil, io.EOF
+	} else if count > 0 && count < len(names) {
+		names = names[:count]
+	}
+	f.read += len(names)
+	infos := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		infos[i] = f.node.children[name]
+	}
+	return infos, nil
+}
+
+func (f *servedFile) Stat() (os.FileInfo, error) {
+	return f.node, nil
+}
+
+func (f *servedFile) Write(data []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *servedFile) Close() error {
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+// Serve the files of 'volumes' read-only over WebDAV and HTTP at /token/ until 'stop' is closed.
+// One volume is opened right away and served at the top. More than one are each served in a folder
+// named after the volume, so their files can't collide, and each is only opened once it's used.
+func serveVolumes(volumes []*servedVolume, listener net.Listener, token string, stop <-chan struct{}) error {
+	// Opening a volume goes through work(), so only open one at a time
+	var lock sync.Mutex
+	var opened []*openedVolume
+	defer func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, i := range opened {
+			i.file.Close()
+		}
+	}()
+	open := func(volume *servedVolume) func() (*openedVolume, error) {
+		return func() (*openedVolume, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			v, err := volume.open()
+			if err == nil {
+				opened = append(opened, v)
+			}
+			return v, err
+		}
+	}
+
+	root := &servedNode{children: make(map[string]*servedNode)}
+	if len(volumes) == 1 {
+		root.open = open(volumes[0])
+		if err := root.load(); err != nil {
+			return err
+		}
+	} else {
+		for _, i := range volumes {
+			root.children[i.name] = &servedNode{name: i.name, children: make(map[string]*servedNode), open: open(i)}
+		}
+	}
+	files := &servedFS{root}
+	dav := &webdav.Handler{Prefix: "/" + token, FileSystem: files, LockSystem: webdav.NewMemLS()}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// Anything without the token is treated as if it doesn't exist
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if subtle.ConstantTimeCompare([]byte(parts[0]), []byte(token)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			// Browsers get a list of the files in a folder
+			name := strings.TrimPrefix(r.URL.Path, "/"+token)
+			if n, err := files.find(name); err == nil && n.IsDir() {
+				if !strings.HasSuffix(r.URL.Path, "/") {
+					http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+					return
+				}
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				fmt.Fprintf(w, "<!DOCTYPE html>\n<title>%s</title>\n<pre>\n", html.EscapeString(path.Clean("/"+name)))
+				for _, i := range n.names() {
+					link, label := url.PathEscape(i), html.EscapeString(i)
+					if n.children[i].IsDir() {
+						link, label = link+"/", label+"/"
+					}
+					fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link, label)
+				}
+				fmt.Fprintln(w, "</pre>")
+				return
+			}
+		case http.MethodOptions, "PROPFIND":
+		default:
+			http.Error(w, "The files are read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(handler)}
+	go func() {
+		<-stop
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// One file in the state of an incremental backup
+type backupEntry struct {
+	size     int64
+	modified int64  // Modification time in Unix nanoseconds
+	hash     string // Hex SHA-256 of the contents
+}
+
+// Encrypt or decrypt 'in' into 'out' as if it was dropped, with 'setup' setting the options
+func workFile(in string, out string, setup func()) error {
+	onDrop([]string{in})
+	for scanning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mainStatusColor == RED {
+		return errors.New(mainStatus)
+	}
+	setup()
+	outputFile = out
+	fastDecode = true
+	work()
+	working = false
+	if mainStatusColor == RED {
+		return errors.New(mainStatus)
+	}
+	return nil
+}
+
+// Hash a file for the state of an incremental backup
+func hashFile(path string) (string, error) {
+	fin, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fin.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, fin); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// The run number of an incremental backup volume named like "folder-0001.zip.pcv", or 0
+func backupNumber(path string) int {
+	name := strings.TrimSuffix(filepath.Base(path), ".zip.pcv")
+	n, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil || !strings.HasSuffix(path, ".zip.pcv") {
+		return 0
+	}
+	return n
+}
+
+// Encrypt what changed in 'folder' since the last run into a new volume in 'dest'
+// Returns the new volume, or "" if nothing changed, and how many files changed and were deleted
+func backupFolder(folder string, dest string, setup func()) (string, int, int, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return "", 0, 0, err
+	}
+	name := filepath.Base(folder)
+	statePath := filepath.Join(dest, name+".state.pcv")
+	// The plaintext state only exists in 'dest', never in the system's temporary folder
+	tmp, err := os.CreateTemp(dest, name+"-*.state.csv")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name() + ".incomplete")
+
+	// Derive the keys once for the state and the new volume
+	batchKeys = make(map[string][]byte)
+	defer endBatch()
+
+	// Read the state left by the last run
+	old := make(map[string]backupEntry)
+	if _, err := os.Stat(statePath); err == nil {
+		if err := workFile(statePath, tmp.Name(), setup); err != nil {
+			return "", 0, 0, fmt.Errorf("unable to read the state (%s)", err)
+		}
+		fin, err := os.Open(tmp.Name())
+		if err != nil {
+			return "", 0, 0, err
+		}
+		rows, err := csv.NewReader(fin).ReadAll()
+		fin.Close()
+		os.Remove(tmp.Name())
+		if err != nil || len(rows) == 0 {
+			return "", 0, 0, errors.New("the state is damaged")
+		}
+		for _, i := range rows[1:] {
+			if len(i) != 4 {
+				return "", 0, 0, errors.New("the state is damaged")
+			}
+			size, err1 := strconv.ParseInt(i[1], 10, 64)
+			modified, err2 := strconv.ParseInt(i[2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return "", 0, 0, errors.New("the state is damaged")
+			}
+			old[i[0]] = backupEntry{size, modified, i[3]}
+		}
+	}
+
+	// Find the new and changed files, only hashing those whose size or time changed
+	current := make(map[string]backupEntry)
+	var changed []string
+	err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == dest {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.Dir(folder), path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		entry := backupEntry{size: info.Size(), modified: info.ModTime().UnixNano()}
+		last, ok := old[rel]
+		if ok && last.size == entry.size && last.modified == entry.modified {
+			entry.hash = last.hash
+		} else {
+			if entry.hash, err = hashFile(path); err != nil {
+				return err
+			}
+			if !ok || last.hash != entry.hash {
+				changed = append(changed, path)
+			}
+		}
+		current[rel] = entry
+		return nil
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+	var deleted []string
+	for rel := range old {
+		if _, ok := current[rel]; !ok {
+			deleted = append(deleted, rel)
+		}
+	}
+	sort.Strings(deleted)
+	if len(changed) == 0 && len(deleted) == 0 {
+		return "", 0, 0, nil
+	}
+
+	// Number the new volume after the last one
+	n := 1
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	for _, i := range entries {
+		if strings.HasPrefix(i.Name(), name+"-") && backupNumber(i.Name()) >= n {
+			n = backupNumber(i.Name()) + 1
+		}
+	}
+	volume := filepath.Join(dest, fmt.Sprintf("%s-%04d.zip.pcv", name, n))
+	err = workFile(folder, volume, func() {
+		setup()
+		allFiles = changed
+		if len(deleted) > 0 {
+			deletedFiles = deleted
+		}
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// Replace the state, which the next run compares against
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"path", "size", "modified", "sha256"})
+	paths := make([]string, 0, len(current))
+	for rel := range current {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+	for _, rel := range paths {
+		i := current[rel]
+		w.Write([]string{rel, strconv.FormatInt(i.size, 10), strconv.FormatInt(i.modified, 10), i.hash})
+	}
+	w.Flush()
+	if err := os.WriteFile(tmp.Name(), buf.Bytes(), 0600); err != nil {
+		return volume, len(changed), len(deleted), err
+	}
+	err = workFile(tmp.Name(), statePath, setup)
+	os.Remove(tmp.Name())
+	if err != nil {
+		return volume, len(changed), len(deleted), fmt.Errorf("unable to save the state (%s)", err)
+	}
+	return volume, len(changed), len(deleted), nil
+}
+
+// Replay a chain of incremental backup volumes into 'dest', oldest first
+func restoreBackups(volumes []string, dest string, setup func()) error {
+	volumes = append([]string{}, volumes...)
+	sort.SliceStable(volumes, func(i, j int) bool {
+		return backupNumber(volumes[i]) < backupNumber(volumes[j])
+	})
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+	list := filepath.Join(dest, deletedList)
+	if _, err := os.Stat(list); err == nil {
+		return errors.New("please remove " + list)
+	}
+
+	batchKeys = make(map[string][]byte)
+	defer endBatch()
+	for _, volume := range volumes {
+		out := filepath.Join(dest, strings.TrimSuffix(filepath.Base(volume), ".pcv"))
+		err := workFile(volume, out, func() {
+			setup()
+			autoUnzip, sameLevel = true, true
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %s", filepath.Base(volume), err)
+		}
+
+		// Remove the files that were gone when this volume was made
+		data, err := os.ReadFile(list)
+		if err != nil {
+			continue
+		}
+		os.Remove(list)
+		for _, i := range strings.Split(string(data), "\n") {
+			if !filepath.IsLocal(filepath.FromSlash(i)) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dest, filepath.FromSlash(i))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Sizes of the chunks that repositories split files into, about 1 MiB on average
+const chunkMin = 256 * KiB
+const chunkMax = 4 * MiB
+const chunkMask = 1<<20 - 1
+
+// An unlocked repository of deduplicated chunks and the snapshots that list them
+type repository struct {
+	path   string
+	encKey []byte      // XChaCha20 key of chunks and snapshots
+	macKey []byte      // Keyed BLAKE2b-512 of their nonce and ciphertext
+	idKey  []byte      // Keyed BLAKE2b-256 of chunk contents, which names the chunks
+	gear   [256]uint64 // Gear hash table for chunk boundaries, keyed so they don't reveal the contents
+}
+
+// One file in a snapshot
+type snapshotFile struct {
+	Path     string   `json:"path"` // Relative to the folder's parent, separated by forward slashes
+	Size     int64    `json:"size"`
+	Modified int64    `json:"modified"` // Unix nanoseconds
+	Chunks   []string `json:"chunks"`
+}
+
+// The files of a folder at the time it was saved to a repository
+type snapshot struct {
+	Time  int64          `json:"time"`
+	Files []snapshotFile `json:"files"`
+}
+
+// Split a stream where the content says to, so that inserting or removing bytes only changes
+// the chunks around them and the rest are found again in the repository
+type chunker struct {
+	r    *bufio.Reader
+	gear *[256]uint64
+}
+
+func (c *chunker) next() ([]byte, error) {
+	data := make([]byte, chunkMin, chunkMax)
+	n, err := io.ReadFull(c.r, data)
+	if err == io.ErrUnexpectedEOF {
+		return data[:n], nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Cut where the gear hash of the last 64 bytes has its low 20 bits zero
+	var h uint64
+	for len(data) < chunkMax {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		data = append(data, b)
+		h = h<<1 + c.gear[b]
+		if h&chunkMask == 0 {
+			break
+		}
+	}
+	return data, nil
+}
+
+// The config file of a repository, with what's needed to unlock it
+type repositoryConfig struct {
+	Version int      `json:"version"`
+	Options []string `json:"options"` // "paranoid", "keyfiles", "ordered"
+	Salt    []byte   `json:"salt"`    // Argon2 salt
+	Check   []byte   `json:"check"`   // Checks the password and keyfiles
+}
+
+// Derive the keys of a repository from its salt, the password, and the keyfile key
+func repositoryKeys(password string, keyfileKey []byte, salt []byte, paranoid bool) (*repository, []byte) {
+	key := passwordKey(password, salt, paranoid)
+	if keyfileKey != nil {
+		key = xorKeys(key, keyfileKey)
+	}
+	subkeys := make([]byte, 128+256*8)
+	if _, err := io.ReadFull(hkdf.New(sha3.New256, key, salt, []byte("repository")), subkeys); err != nil {
+		panic(errors.New("fatal hkdf.Read error"))
+	}
+	r := &repository{encKey: subkeys[:32], macKey: subkeys[32:64], idKey: subkeys[64:96]}
+	for i := range r.gear {
+		r.gear[i] = binary.LittleEndian.Uint64(subkeys[128+i*8:])
+	}
+	return r, subkeys[96:128]
+}
+
+// Create a repository in the empty or missing folder 'path'
+func createRepository(path string, password string, keyfiles []string, ordered bool, paranoid bool) error {
+	if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 {
+		return errors.New(path + " isn't empty")
+	}
+	var keyfileKey []byte
+	var options []string
+	if paranoid {
+		options = append(options, "paranoid")
+	}
+	if len(keyfiles) > 0 {
+		var err error
+		if keyfileKey, err = readKeyfiles(keyfiles, ordered); err != nil {
+			return err
+		}
+		options = append(options, "keyfiles")
+		if ordered {
+			options = append(options, "ordered")
+		}
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	_, check := repositoryKeys(password, keyfileKey, salt, paranoid)
+	for _, i := range []string{"chunks", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(path, i), 0700); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(repositoryConfig{1, options, salt, check}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(path, "config"), append(data, '\n'), 0600)
+}
+
+// Unlock the repository in 'path', checking the password and keyfiles
+func openRepository(path string, password string, keyfiles []string) (*repository, error) {
+	data, err := os.ReadFile(filepath.Join(path, "config"))
+	if err != nil {
+		return nil, errors.New("not a repository")
+	}
+	var config repositoryConfig
+	if json.Unmarshal(data, &config) != nil || len(config.Salt) != 32 || len(config.Check) != 32 {
+		return nil, errors.New("not a repository")
+	} else if config.Version != 1 {
+		return nil, fmt.Errorf("unsupported repository version %d", config.Version)
+	}
+	has := func(option string) bool {
+		for _, i := range config.Options {
+			if i == option {
+				return true
+			}
+		}
+		return false
+	}
+	var keyfileKey []byte
+	if has("keyfiles") {
+		if len(keyfiles) == 0 {
+			return nil, errors.New("the repository needs keyfiles")
+		}
+		if keyfileKey, err = readKeyfiles(keyfiles, has("ordered")); err != nil {
+			return nil, err
+		}
+	}
+	r, check := repositoryKeys(password, keyfileKey, config.Salt, has("paranoid"))
+	if subtle.ConstantTimeCompare(check, config.Check) != 1 {
+		return nil, errors.New("incorrect password or keyfiles")
+	}
+	r.path = path
+	return r, nil
+}
+
+// Encrypt and authenticate a chunk or snapshot as [nonce][ciphertext][mac]
+// The MAC also covers 'name', so a sealed chunk or snapshot can't be passed off as another one
+func (r *repository) seal(name string, data []byte) []byte {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	chacha, err := chacha20.NewUnauthenticatedCipher(r.encKey, nonce)
+	if err != nil {
+		panic(err)
+	}
+	sealed := make([]byte, 24+len(data), 24+len(data)+64)
+	copy(sealed, nonce)
+	chacha.XORKeyStream(sealed[24:], data)
+	return append(sealed, r.sealMAC(name, sealed)...)
+}
+
+// The keyed BLAKE2b-512 of the length of 'name', 'name', and the nonce and ciphertext
+func (r *repository) sealMAC(name string, body []byte) []byte {
+	mac, err := blake2b.New512(r.macKey)
+	if err != nil {
+		panic(err)
+	}
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(len(name)))
+	mac.Write(length)
+	mac.Write([]byte(name))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Check and decrypt what seal() made under the same name
+func (r *repository) open(name string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 24+64 {
+		return nil, errors.New("damaged or modified")
+	}
+	body := sealed[:len(sealed)-64]
+	if !hmac.Equal(r.sealMAC(name, body), sealed[len(body):]) {
+		return nil, errors.New("damaged or modified")
+	}
+	chacha, err := chacha20.NewUnauthenticatedCipher(r.encKey, body[:24])
+	if err != nil {
+		panic(err)
+	}
+	data := make([]byte, len(body)-24)
+	chacha.XORKeyStream(data, body[24:])
+	return data, nil
+}
+
+// Write a file so that it's either all there or not there at all
+func (r *repository) write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".incomplete", data, 0600); err != nil {
+		os.Remove(path + ".incomplete")
+		return err
+	}
+	return os.Rename(path+".incomplete", path)
+}
+
+// Where the chunk with ID 'id' is stored
+func (r *repository) chunkPath(id string) string {
+	return filepath.Join(r.path, "chunks", id[:2], id)
+}
+
+// The ID of a chunk, the hex of its keyed BLAKE2b-256
+func (r *repository) chunkID(data []byte) string {
+	hasher, err := blake2b.New256(r.idKey)
+	if err != nil {
+		panic(err)
+	}
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// Store a chunk unless the repository already has it
+// Returns the chunk's ID and whether it was new
+func (r *repository) putChunk(data []byte) (string, bool, error) {
+	id := r.chunkID(data)
+	if _, err := os.Stat(r.chunkPath(id)); err == nil {
+		return id, false, nil
+	}
+	return id, true, r.write(r.chunkPath(id), r.seal("chunks/"+id, data))
+}
+
+// Read a chunk back
+func (r *repository) getChunk(id string) ([]byte, error) {
+	if len(id) != 64 {
+		return nil, errors.New("bad chunk ID " + id)
+	}
+	sealed, err := os.ReadFile(r.chunkPath(id))
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.open("chunks/"+id, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is %s", id, err)
+	}
+
+	// Make sure it's the chunk that was asked for, not another one moved in its place
+	if r.chunkID(data) != id {
+		return nil, fmt.Errorf("chunk %s is damaged or modified", id)
+	}
+	return data, nil
+}
+
+// The names of the snapshots, oldest first
+func (r *repository) snapshots() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.path, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, i := range entries {
+		if !strings.HasSuffix(i.Name(), ".incomplete") {
+			names = append(names, i.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Read the snapshot named 'name'
+func (r *repository) loadSnapshot(name string) (*snapshot, error) {
+	if strings.ContainsAny(name, `/\`) || !filepath.IsLocal(name) {
+		return nil, errors.New("bad snapshot name " + name)
+	}
+	sealed, err := os.ReadFile(filepath.Join(r.path, "snapshots", name))
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.open("snapshots/"+name, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s is %s", name, err)
+	}
+	s := &snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save the files in 'folder' as a new snapshot, storing only the chunks the repository doesn't have
+// Returns the snapshot's name, the number of new chunks, and their size before encryption
+func (r *repository) saveSnapshot(folder string) (string, int, int64, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	repo, err := filepath.Abs(r.path)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// Files whose size and time match the last snapshot still have the same chunks
+	last := make(map[string]snapshotFile)
+	names, err := r.snapshots()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if len(names) > 0 {
+		s, err := r.loadSnapshot(names[len(names)-1])
+		if err != nil {
+			return "", 0, 0, err
+		}
+		for _, i := range s.Files {
+			last[i.Path] = i
+		}
+	}
+
+	s := snapshot{Time: time.Now().UnixNano()}
+	var chunks int
+	var stored int64
+	err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == repo {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.Dir(folder), path)
+		if err != nil {
+			return err
+		}
+		file := snapshotFile{Path: filepath.ToSlash(rel), Size: info.Size(), Modified: info.ModTime().UnixNano()}
+		if old, ok := last[file.Path]; ok && old.Size == file.Size && old.Modified == file.Modified {
+			file.Chunks = old.Chunks
+			s.Files = append(s.Files, file)
+			return nil
+		}
+
+		fin, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fin.Close()
+		c := chunker{r: bufio.NewReaderSize(fin, MiB), gear: &r.gear}
+		file.Chunks = []string{}
+		for {
+			data, err := c.next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			id, added, err := r.putChunk(data)
+			if err != nil {
+				return err
+			}
+			if added {
+				chunks++
+				stored += int64(len(data))
+			}
+			file.Chunks = append(file.Chunks, id)
+		}
+		s.Files = append(s.Files, file)
+		return nil
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// Name the snapshot by when it was made
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	name := time.Now().UTC().Format("20060102-150405")
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(r.path, "snapshots", name)); err != nil {
+			break
+		}
+		name = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102-150405"), i)
+	}
+	return name, chunks, stored, r.write(filepath.Join(r.path, "snapshots", name), r.seal("snapshots/"+name, data))
+}
+
+// Write the files of a snapshot into 'dest'
+func (r *repository) restoreSnapshot(name string, dest string) error {
+	s, err := r.loadSnapshot(name)
+	if err != nil {
+		return err
+	}
+	for _, file := range s.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return errors.New("potentially malicious path " + file.Path)
+		}
+		path := filepath.Join(dest, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		fout, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, id := range file.Chunks {
+			data, err := r.getChunk(id)
+			if err == nil {
+				_, err = fout.Write(data)
+			}
+			if err != nil {
+				fout.Close()
+				return err
+			}
+			size += int64(len(data))
+		}
+		if err := fout.Close(); err != nil {
+			return err
+		}
+		if size != file.Size {
+			return errors.New(file.Path + " doesn't have the size it was saved with")
+		}
+		modified := time.Unix(0, file.Modified)
+		os.Chtimes(path, modified, modified)
+	}
+	return nil
 }
 
-// Convert bytes to KiB, MiB, etc.
-func sizeify(size int64) string {
-	if size >= int64(TiB) {
-		return fmt.Sprintf("%.2f TiB", float64(size)/float64(TiB))
-	} else if size >= int64(GiB) {
-		return fmt.Sprintf("%.2f GiB", float64(size)/float64(GiB))
-	} else if size >= int64(MiB) {
-		return fmt.Sprintf("%.2f MiB", float64(size)/float64(MiB))
-	} else {
-		return fmt.Sprintf("%.2f KiB", float64(size)/float64(KiB))
+// Add the -p flag, which falls back to $PICOCRYPT_PASSWORD once the flags are parsed
+// The variable isn't the flag's default, as usage messages print the defaults
+func passwordFlag(set *flag.FlagSet) func() string {
+	pass := set.String("p", "", "password (default: $PICOCRYPT_PASSWORD)")
+	return func() string {
+		if *pass == "" {
+			return os.Getenv("PICOCRYPT_PASSWORD")
+		}
+		return *pass
 	}
 }
 
-func unpackArchive(zipPath string) error {
-	reader, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return err
+// Read the keyfiles given with -k and -set, printing why if they can't be read
+// Returns the keyfiles, whether the set wants them ordered, and whether they were read
+func cliKeyfiles(keys string, keyfileSet string) ([]string, bool, bool) {
+	var paths []string
+	setOrdered := false
+	if keys != "" {
+		var err error
+		if paths, err = expandKeyfiles(strings.Split(keys, ",")); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read keyfiles:", err)
+			return nil, false, false
+		}
 	}
-	defer reader.Close()
+	if keyfileSet != "" {
+		more, ordered, err := loadKeyfileSet(keyfileSet)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to load keyfiles:", err)
+			return nil, false, false
+		}
+		paths, setOrdered = append(paths, more...), ordered
+	}
+	return paths, setOrdered, true
+}
 
-	var totalSize int64
-	for _, f := range reader.File {
-		totalSize += int64(f.UncompressedSize64)
+// Read the identities given with -i, printing why if they can't be read
+func cliIdentities(list string) ([]*pem.Block, bool) {
+	var ids []*pem.Block
+	if list != "" {
+		for _, i := range strings.Split(list, ",") {
+			block, err := readIdentity(i)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to read identity:", err)
+				return nil, false
+			}
+			ids = append(ids, block)
+		}
 	}
+	return ids, true
+}
 
-	var extractDir string
-	if sameLevel {
-		extractDir = filepath.Dir(zipPath)
-	} else {
-		extractDir = filepath.Join(filepath.Dir(zipPath), strings.TrimSuffix(filepath.Base(zipPath), ".zip"))
+// Encrypt, decrypt, and manage signing keys without the GUI
+func cli(args []string) int {
+	set := flag.NewFlagSet("picocrypt "+args[0], flag.ContinueOnError)
+
+	if args[0] == "job" {
+		return runJob(os.Stdin, os.Stdout)
 	}
 
-	var done int64
-	startTime := time.Now()
+	if args[0] == "keygen" {
+		name := set.String("name", "", "name of the key's owner (default: the file name)")
+		identity := set.Bool("identity", false, "create an identity for receiving volumes instead")
+		if set.Parse(args[1:]) != nil || set.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt keygen [-name name] [-identity] file.key")
+			return 2
+		}
+		path := set.Arg(0)
+		if *name == "" {
+			*name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		if *identity {
+			if err := createIdentity(path, *name); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to create identity:", err)
+				return 1
+			}
+			fmt.Println("Created identity")
+			return 0
+		}
+		key, err := createSigningKey(path, *name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create signing key:", err)
+			return 1
+		}
+		fmt.Println("Created signing key " + fingerprint(key.Public().(ed25519.PublicKey)))
+		return 0
+	}
 
-	for _, f := range reader.File {
-		if strings.Contains(f.Name, "..") {
-			return errors.New("potentially malicious zip item path")
+	if args[0] == "trust" {
+		remove := set.Bool("remove", false, "stop trusting the given public keys")
+		if set.Parse(args[1:]) != nil {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt trust [-remove] [file.pub ...]")
+			return 2
 		}
-		outPath := filepath.Join(extractDir, f.Name)
+		for _, path := range set.Args() {
+			var err error
+			if *remove {
+				var block *pem.Block
+				if block, err = readPublicKey(path); err == nil {
+					err = untrustSigner(block.Bytes)
+				}
+			} else {
+				_, err = trustSigner(path)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, path+":", err)
+				return 1
+			}
+		}
+		for _, i := range trustedSigners() {
+			fmt.Println(fingerprint(i.Bytes) + "  " + i.Headers["Name"])
+		}
+		return 0
+	}
 
-		// Make directory if current entry is a folder
-		if f.FileInfo().IsDir() {
-			if err := os.MkdirAll(outPath, 0700); err != nil {
-				return err
+	if args[0] == "keyfiles" {
+		ordered := set.Bool("ordered", false, "require the keyfiles in the given order")
+		remove := set.Bool("remove", false, "remove the named set")
+		if set.Parse(args[1:]) != nil || (*remove && set.NArg() != 1) || (!*remove && set.NArg() == 1) {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt keyfiles [-ordered] name keyfiles...")
+			fmt.Fprintln(os.Stderr, "       picocrypt keyfiles -remove name")
+			return 2
+		}
+		var err error
+		if *remove {
+			err = removeKeyfileSet(set.Arg(0))
+		} else if set.NArg() > 1 {
+			var paths []string
+			if paths, err = expandKeyfiles(set.Args()[1:]); err == nil {
+				for _, i := range paths {
+					if _, err = os.Stat(i); err != nil {
+						break
+					}
+				}
+			}
+			if err == nil {
+				err = saveKeyfileSet(set.Arg(0), paths, *ordered)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to update keyfile sets:", err)
+			return 1
+		}
+		for _, i := range keyfileSets() {
+			info := "1 keyfile"
+			if n := len(i.Keyfiles); n > 1 {
+				info = fmt.Sprintf("%d keyfiles", n)
 			}
+			if i.Ordered {
+				info += ", ordered"
+			}
+			fmt.Println(i.Name + "  (" + info + ")")
 		}
+		return 0
 	}
 
-	for i, f := range reader.File {
-		if strings.Contains(f.Name, "..") {
-			return errors.New("potentially malicious zip item path")
+	if args[0] == "words" {
+		restore := set.Bool("restore", false, "read words from standard input and write the keyfile")
+		if set.Parse(args[1:]) != nil || set.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt words [-restore] keyfile.bin")
+			return 2
+		}
+		path := set.Arg(0)
+		if !*restore {
+			words, err := exportWords(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Only generated keyfiles can be written as words:", err)
+				return 1
+			}
+			fmt.Println(words)
+			return 0
+		}
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprintln(os.Stderr, "Please remove "+path)
+			return 1
 		}
+		text, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			panic(err)
+		}
+		data, err := wordsToKeyfile(string(text))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid words:", err)
+			return 1
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to create keyfile:", err)
+			return 1
+		}
+		fmt.Println("Restored keyfile")
+		return 0
+	}
 
-		// Already handled above
-		if f.FileInfo().IsDir() {
-			continue
+	if args[0] == "paper" {
+		restore := set.Bool("restore", false, "restore the file from scans of a paper backup")
+		output := set.String("o", "", "output file (default: the file with .pdf added)")
+		if set.Parse(args[1:]) != nil || set.NArg() == 0 || (!*restore && set.NArg() != 1) || (*restore && *output == "") {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt paper [-o output.pdf|png] file")
+			fmt.Fprintln(os.Stderr, "       picocrypt paper -restore -o output scans...")
+			return 2
+		}
+		if *output == "" {
+			*output = set.Arg(0) + ".pdf"
+		}
+		if _, err := os.Stat(*output); err == nil {
+			fmt.Fprintln(os.Stderr, "Please remove "+*output)
+			return 1
+		}
+		if *restore {
+			data, err := importPaper(set.Args())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to restore:", err)
+				return 1
+			}
+			if err := os.WriteFile(*output, data, 0600); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to save the restored file:", err)
+				return 1
+			}
+			fmt.Println("Restored, checksum " + paperChecksum(data))
+			return 0
 		}
+		checksum, err := exportPaper(set.Arg(0), *output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to print:", err)
+			return 1
+		}
+		fmt.Println("Printed, checksum " + checksum)
+		return 0
+	}
 
-		outPath := filepath.Join(extractDir, f.Name)
+	if args[0] == "serve" {
+		pass := passwordFlag(set)
+		keys := set.String("k", "", "comma-separated list of keyfiles")
+		keyfileSet := set.String("set", "", "use the keyfiles saved as this set")
+		identities := set.String("i", "", "comma-separated list of identities to decrypt with")
+		port := set.Int("port", 0, "port to listen on (default: any free port)")
+		if set.Parse(args[1:]) != nil || set.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt serve [-p password] [-k keyfiles] [-set name] [-i identities] [-port port] volume|folder")
+			return 2
+		}
+		paths, setOrdered, ok := cliKeyfiles(*keys, *keyfileSet)
+		if !ok {
+			return 1
+		}
+		ids, ok := cliIdentities(*identities)
+		if !ok {
+			return 1
+		}
+		if pass() == "" && len(paths) == 0 && len(ids) == 0 {
+			fmt.Fprintln(os.Stderr, "A password, keyfiles, or identities are required")
+			return 2
+		}
 
-		// Otherwise create necessary parent directories
-		if err := os.MkdirAll(filepath.Dir(outPath), 0700); err != nil {
-			return err
+		// Serve every volume in a folder, or just the one given
+		names := []string{set.Arg(0)}
+		if stat, err := os.Stat(set.Arg(0)); err == nil && stat.IsDir() {
+			entries, err := os.ReadDir(set.Arg(0))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to read the folder:", err)
+				return 1
+			}
+			names = nil
+			for _, i := range entries {
+				if strings.HasSuffix(i.Name(), ".pcv") || strings.HasSuffix(i.Name(), ".pcv.0") {
+					names = append(names, filepath.Join(set.Arg(0), i.Name()))
+				}
+			}
+		}
+		var volumes []*servedVolume
+		for _, name := range names {
+			volumes = append(volumes, &servedVolume{
+				name: strings.TrimSuffix(filepath.Base(name), ".0"),
+				open: func() (*openedVolume, error) {
+					fmt.Println("Opening " + filepath.Base(name) + "...")
+					volume, err := openVolume(name, func() {
+						password = pass()
+						keyfiles = paths
+						if deniability {
+							keyfileOrdered = keyfileOrdered || setOrdered
+						}
+						recipients = ids
+					})
+					if err != nil {
+						fmt.Fprintln(os.Stderr, "Unable to open "+filepath.Base(name)+":", err)
+					}
+					return volume, err
+				},
+			})
 		}
 
-		// Open the file inside the archive
-		fileInArchive, err := f.Open()
+		// A single volume is opened before listening, so that a wrong password is reported right away
+		if len(volumes) == 1 {
+			volume, err := volumes[0].open()
+			if err != nil {
+				return 1
+			}
+			volumes[0].open = func() (*openedVolume, error) {
+				return volume, nil
+			}
+		}
+
+		// Only this computer can connect, and only with the token
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *port))
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, "Unable to listen:", err)
+			return 1
 		}
-		defer fileInArchive.Close()
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			panic(err)
+		}
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		fmt.Printf("Serving at http://%s/%s/, press Ctrl+C to stop\n", listener.Addr(), hex.EncodeToString(token))
+		if err := serveVolumes(volumes, listener, hex.EncodeToString(token), stop); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to serve:", err)
+			return 1
+		}
+		fmt.Println("Stopped serving")
+		return 0
+	}
 
-		dstFile, err := os.Create(outPath)
+	if args[0] == "mount" {
+		pass := passwordFlag(set)
+		keys := set.String("k", "", "comma-separated list of keyfiles")
+		keyfileSet := set.String("set", "", "use the keyfiles saved as this set")
+		identities := set.String("i", "", "comma-separated list of identities to decrypt with")
+		if set.Parse(args[1:]) != nil || set.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt mount [-p password] [-k keyfiles] [-set name] [-i identities] volume folder")
+			return 2
+		}
+		paths, setOrdered, ok := cliKeyfiles(*keys, *keyfileSet)
+		if !ok {
+			return 1
+		}
+		ids, ok := cliIdentities(*identities)
+		if !ok {
+			return 1
+		}
+		if pass() == "" && len(paths) == 0 && len(ids) == 0 {
+			fmt.Fprintln(os.Stderr, "A password, keyfiles, or identities are required")
+			return 2
+		}
+
+		fmt.Println("Decrypting " + filepath.Base(set.Arg(0)) + "...")
+		volume, err := openVolume(set.Arg(0), func() {
+			password = pass()
+			keyfiles = paths
+			if deniability {
+				keyfileOrdered = keyfileOrdered || setOrdered
+			}
+			recipients = ids
+		})
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, "Unable to open the volume:", err)
+			return 1
+		}
+		defer volume.file.Close()
+
+		// Unmount on Ctrl+C
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		fmt.Println("Mounted at " + set.Arg(1) + ", press Ctrl+C to unmount")
+		if err := mountVolume(volume, set.Arg(1), stop); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to mount:", err)
+			return 1
 		}
+		fmt.Println("Unmounted")
+		return 0
+	}
 
-		// Read from zip in chunks to update progress
-		buffer := make([]byte, MiB)
-		for {
-			n, readErr := fileInArchive.Read(buffer)
-			if n > 0 {
-				_, writeErr := dstFile.Write(buffer[:n])
-				if writeErr != nil {
-					dstFile.Close()
-					os.Remove(dstFile.Name())
-					return writeErr
-				}
+	if args[0] == "watch" {
+		pass := passwordFlag(set)
+		keys := set.String("k", "", "comma-separated list of keyfiles")
+		ordered := set.Bool("ordered", false, "require the keyfiles in the given order")
+		keyfileSet := set.String("set", "", "use the keyfiles saved as this set")
+		profileFlag := set.String("profile", "", "encrypt with the options saved as this profile")
+		output := set.String("o", "", "folder to save the volumes in (default: next to each file)")
+		deleteFlag := set.Bool("delete", false, "delete each file once it's encrypted")
+		settle := set.Duration("settle", 5*time.Second, "how long a file must stay the same before it's encrypted")
+		logFlag := set.String("log", "", "append the log to this file instead of printing it")
+		if set.Parse(args[1:]) != nil || set.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt watch [-p password] [-k keyfiles] [-set name] [-ordered] [-profile name] [-o output] [-delete] [-settle 5s] [-log file] folders...")
+			return 2
+		}
+		paths, setOrdered, ok := cliKeyfiles(*keys, *keyfileSet)
+		if !ok {
+			return 1
+		}
+		if pass() == "" && len(paths) == 0 {
+			fmt.Fprintln(os.Stderr, "A password or keyfiles are required")
+			return 2
+		}
+		var profile *profile
+		if *profileFlag != "" {
+			if profile = findProfile(*profileFlag); profile == nil {
+				fmt.Fprintln(os.Stderr, "No profile named "+*profileFlag)
+				return 1
+			}
+		}
+		logger := log.New(os.Stdout, "", log.LstdFlags)
+		if *logFlag != "" {
+			fout, err := os.OpenFile(*logFlag, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to open the log:", err)
+				return 1
+			}
+			defer fout.Close()
+			logger.SetOutput(fout)
+		}
 
-				done += int64(n)
-				progress, speed, eta = statify(done, totalSize, startTime)
-				progressInfo = fmt.Sprintf("%d/%d", i+1, len(reader.File))
-				popupStatus = fmt.Sprintf("Unpacking at %.2f MiB/s (ETA: %s)", speed, eta)
-				giu.Update()
+		// Dropping resets the options, so set them again for each file
+		setup := func() {
+			if profile != nil {
+				applyProfile(profile)
 			}
-			if readErr != nil {
-				if readErr == io.EOF {
-					break
+			password, cpassword = pass(), pass()
+			keyfiles = paths
+			keyfileOrdered = *ordered || setOrdered
+			delete = *deleteFlag
+		}
+
+		// Stop on Ctrl+C, cancelling the file being encrypted so nothing partial is left
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			working = false
+			close(stop)
+		}()
+		logger.Println("Watching " + strings.Join(set.Args(), ", "))
+		if err := watchFolders(set.Args(), *output, *settle, setup, logger, stop); err != nil {
+			logger.Println("Stopped watching:", err)
+			return 1
+		}
+		logger.Println("Stopped watching")
+		return 0
+	}
+
+	if args[0] == "snapshot" {
+		pass := passwordFlag(set)
+		keys := set.String("k", "", "comma-separated list of keyfiles")
+		ordered := set.Bool("ordered", false, "require the keyfiles in the given order")
+		keyfileSet := set.String("set", "", "use the keyfiles saved as this set")
+		create := set.Bool("init", false, "create a new repository")
+		paranoidFlag := set.Bool("paranoid", false, "use paranoid mode for a new repository")
+		list := set.Bool("list", false, "list the snapshots in the repository")
+		restore := set.String("restore", "", "restore the snapshot with this name")
+		output := set.String("o", ".", "folder to restore into")
+		err := set.Parse(args[1:])
+		needed := 2 // The repository and the folder to save
+		if *create || *list || *restore != "" {
+			needed = 1
+		}
+		if err != nil || set.NArg() != needed {
+			fmt.Fprintln(os.Stderr, "usage: picocrypt snapshot -init [-p password] [-k keyfiles] [-set name] [-ordered] [-paranoid] repository")
+			fmt.Fprintln(os.Stderr, "       picocrypt snapshot [-p password] [-k keyfiles] [-set name] repository folder")
+			fmt.Fprintln(os.Stderr, "       picocrypt snapshot -list [-p password] [-k keyfiles] [-set name] repository")
+			fmt.Fprintln(os.Stderr, "       picocrypt snapshot -restore name [-p password] [-k keyfiles] [-set name] [-o folder] repository")
+			return 2
+		}
+		paths, setOrdered, ok := cliKeyfiles(*keys, *keyfileSet)
+		if !ok {
+			return 1
+		}
+		if pass() == "" && len(paths) == 0 {
+			fmt.Fprintln(os.Stderr, "A password or keyfiles are required")
+			return 2
+		}
+
+		if *create {
+			if err := createRepository(set.Arg(0), pass(), paths, *ordered || setOrdered, *paranoidFlag); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to create repository:", err)
+				return 1
+			}
+			fmt.Println("Created repository")
+			return 0
+		}
+		r, err := openRepository(set.Arg(0), pass(), paths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to open repository:", err)
+			return 1
+		}
+		if *list {
+			names, err := r.snapshots()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Unable to list snapshots:", err)
+				return 1
+			}
+			for _, name := range names {
+				s, err := r.loadSnapshot(name)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Unable to read snapshot:", err)
+					return 1
 				}
-				dstFile.Close()
-				return readErr
+				var size int64
+				for _, i := range s.Files {
+					size += i.Size
+				}
+				fmt.Printf("%s  %d files  %s\n", name, len(s.Files), sizeify(size))
 			}
+			return 0
 		}
-		dstFile.Close()
+		if *restore != "" {
+			if err := r.restoreSnapshot(*restore, *output); err != nil {
+				fmt.Fprintln(os.Stderr, "Restore failed:", err)
+				return 1
+			}
+			fmt.Println("Restored snapshot " + *restore)
+			return 0
+		}
+		name, chunks, stored, err := r.saveSnapshot(set.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Snapshot failed:", err)
+			return 1
+		}
+		fmt.Printf("Saved snapshot %s with %d new chunks (%s)\n", name, chunks, sizeify(stored))
+		return 0
 	}
 
-	return nil
+	if args[0] == "backup" || args[0] == "restore" {
+		pass := passwordFlag(set)
+		keys := set.String("k", "", "comma-separated list of keyfiles")
+		ordered := set.Bool("ordered", false, "require the keyfiles in the given order")
+		keyfileSet := set.String("set", "", "use the keyfiles saved as this set")
+		paranoidFlag, reedsoloFlag, compressFlag, output := new(bool), new(bool), new(bool), new(string)
+		usage := "usage: picocrypt backup [-p password] [-k keyfiles] [-set name] [-ordered] [-paranoid] [-reedsolo] [-compress] folder destination"
+		if args[0] == "backup" {
+			paranoidFlag = set.Bool("paranoid", false, "use paranoid mode")
+			reedsoloFlag = set.Bool("reedsolo", false, "encode the data with Reed-Solomon")
+			compressFlag = set.Bool("compress", false, "compress the files with Deflate")
+		} else {
+			output = set.String("o", ".", "folder to restore into")
+			usage = "usage: picocrypt restore [-p password] [-k keyfiles] [-set name] [-o folder] volumes..."
+		}
+		if set.Parse(args[1:]) != nil || (args[0] == "backup" && set.NArg() != 2) || set.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+
+		paths, setOrdered, ok := cliKeyfiles(*keys, *keyfileSet)
+		if !ok {
+			return 1
+		}
+		if pass() == "" && len(paths) == 0 {
+			fmt.Fprintln(os.Stderr, "A password or keyfiles are required")
+			return 2
+		}
+
+		// Dropping resets the options, so set them again for each volume
+		setup := func() {
+			password, cpassword = pass(), pass()
+			if mode != "decrypt" || keyfile {
+				keyfiles = paths
+			}
+			if mode != "decrypt" {
+				keyfileOrdered = *ordered || setOrdered
+				paranoid, reedsolo, compress = *paranoidFlag, *reedsoloFlag, *compressFlag
+				split, delete = false, false
+			}
+		}
+
+		if args[0] == "backup" {
+			volume, changed, deleted, err := backupFolder(set.Arg(0), set.Arg(1), setup)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Backup failed:", err)
+				return 1
+			}
+			if volume == "" {
+				fmt.Println("No changes since the last backup")
+				return 0
+			}
+			fmt.Printf("Backed up %d changed and %d deleted files to %s\n", changed, deleted, filepath.Base(volume))
+			return 0
+		}
+		if err := restoreBackups(set.Args(), *output, setup); err != nil {
+			fmt.Fprintln(os.Stderr, "Restore failed:", err)
+			return 1
+		}
+		if set.NArg() == 1 {
+			fmt.Println("Restored 1 backup")
+		} else {
+			fmt.Printf("Restored %d backups\n", set.NArg())
+		}
+		return 0
+	}
+
+	pass := passwordFlag(set)
+	keys := set.String("k", "", "comma-separated list of keyfiles")
+	ordered := set.Bool("ordered", false, "require the keyfiles in the given order")
+	keyfileSet := set.String("set", "", "use the keyfiles saved as this set")
+	output := set.String("o", "", "output file, or an s3:// or sftp:// URL to upload the volume to")
+	force := set.Bool("force", false, "force decrypt a damaged or modified volume")
+	paranoidFlag := set.Bool("paranoid", false, "use paranoid mode")
+	reedsoloFlag := set.Bool("reedsolo", false, "encode the data with Reed-Solomon")
+	signFlag := set.String("sign", "", "sign the volume with this signing key")
+	recipientsFlag := set.String("r", "", "comma-separated list of recipients' public keys to encrypt for")
+	identities := set.String("i", "", "comma-separated list of identities to decrypt with")
+	sharesFlag := set.String("shares", "", "generate keyfiles as M/N shares, any M of which decrypt")
+	profileFlag := set.String("profile", "", "start from the options saved as this profile")
+	if set.Parse(args[1:]) != nil || set.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: picocrypt %s [options] files...\n", args[0])
+		return 2
+	}
+
+	// Treat the arguments as if they were dropped into the window
+	onDrop(set.Args())
+	for scanning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mainStatusColor == RED {
+		fmt.Fprintln(os.Stderr, mainStatus)
+		return 1
+	}
+	if mode != args[0] {
+		fmt.Fprintln(os.Stderr, "Can't "+args[0]+" "+inputLabel)
+		return 2
+	}
+
+	password, cpassword = pass(), pass()
+	if *keys != "" {
+		paths, err := expandKeyfiles(strings.Split(*keys, ","))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read keyfiles:", err)
+			return 1
+		}
+		keyfiles = paths
+	}
+	keyfileOrdered = keyfileOrdered || *ordered
+	if *keyfileSet != "" {
+		paths, setOrdered, err := loadKeyfileSet(*keyfileSet)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to load keyfiles:", err)
+			return 1
+		}
+		keyfiles = append(keyfiles, paths...)
+		if mode != "decrypt" || deniability {
+			keyfileOrdered = keyfileOrdered || setOrdered
+		}
+	}
+	if *output != "" && isRemote(*output) && strings.HasSuffix(*output, "/") {
+		outputFile = *output + filepath.Base(outputFile) // Upload under the prefix
+	} else if *output != "" {
+		outputFile = *output
+	}
+	if _, err := os.Stat(outputFile); err == nil {
+		fmt.Fprintln(os.Stderr, "Please remove "+outputFile)
+		return 1
+	}
+	list, read, failed := *recipientsFlag, readRecipient, "Failed to read recipient:"
+	if mode == "decrypt" {
+		list, read, failed = *identities, readIdentity, "Failed to read identity:"
+	}
+	if list != "" {
+		for _, i := range strings.Split(list, ",") {
+			block, err := read(i)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, failed, err)
+				return 1
+			}
+			recipients = append(recipients, block)
+		}
+	}
+	if mode == "encrypt" {
+		if *profileFlag != "" {
+			profile := findProfile(*profileFlag)
+			if profile == nil {
+				fmt.Fprintln(os.Stderr, "No profile named "+*profileFlag)
+				return 1
+			}
+			applyProfile(profile)
+		}
+		paranoid = paranoid || *paranoidFlag
+		reedsolo = reedsolo || *reedsoloFlag
+		if *signFlag != "" {
+			key, _, err := readSigningKey(*signFlag)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to read signing key:", err)
+				return 1
+			}
+			signingKey = key
+			sign = true
+		}
+		if *sharesFlag != "" {
+			var needed, total int32
+			n, _ := fmt.Sscanf(*sharesFlag, "%d/%d", &needed, &total)
+			if n != 2 || needed < 2 || needed > total || total > 255 {
+				fmt.Fprintln(os.Stderr, "Shares must be M/N with 2 <= M <= N <= 255")
+				return 2
+			}
+			if len(keyfiles) > 0 {
+				fmt.Fprintln(os.Stderr, "Shares replace keyfiles")
+				return 2
+			}
+			shares, sharesNeeded, sharesTotal = true, needed, total
+		}
+	} else {
+		keep = *force
+	}
+	if password == "" && len(keyfiles) == 0 && len(recipients) == 0 && !shares {
+		fmt.Fprintln(os.Stderr, "A password, keyfiles, or recipients are required")
+		return 2
+	}
+	if keyfile && len(keyfiles) == 0 {
+		fmt.Fprintln(os.Stderr, "Please select your keyfiles")
+		return 2
+	}
+	if recipient && len(recipients) == 0 {
+		fmt.Fprintln(os.Stderr, "Please select your identity")
+		return 2
+	}
+	if mode == "encrypt" && len(recipients) > 0 && password != "" {
+		fmt.Fprintln(os.Stderr, "Recipients don't use a password")
+		return 2
+	}
+	if mode == "encrypt" && isRemote(outputFile) && (deniability || shares) {
+		fmt.Fprintln(os.Stderr, "Deniability and shares need a local output")
+		return 2
+	}
+	if mode == "encrypt" && isRemote(outputFile) && split {
+		fmt.Fprintln(os.Stderr, "Splitting needs a local output")
+		return 2
+	}
+
+	fastDecode = true
+	work()
+	working = false
+	if mainStatusColor == RED {
+		fmt.Fprintln(os.Stderr, mainStatus)
+		return 1
+	}
+	fmt.Println(mainStatus)
+	return 0
 }
 
 func main() {
 	if rsErr1 != nil || rsErr2 != nil || rsErr3 != nil || rsErr4 != nil || rsErr5 != nil || rsErr6 != nil || rsErr7 != nil {
 		panic(errors.New("rs failed to init"))
 	}
+
+	// Run from the command line instead if a command is given
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "encrypt", "decrypt", "keygen", "trust", "keyfiles", "words", "paper", "job", "backup", "restore", "snapshot", "watch", "mount", "serve":
+			os.Exit(cli(os.Args[1:]))
+		}
+	}
+
 	// Create the main window
 	window = giu.NewMasterWindow("Picocrypt "+version[1:], 318, 507, giu.MasterWindowFlagsNotResizable)
 
@@ -2909,7 +10863,7 @@ func main() {
 	// Set callbacks
 	window.SetDropCallback(onDrop)
 	window.SetCloseCallback(func() bool {
-		return !working && !showProgress
+		return !working && !showProgress && !jobsRunning
 	})
 
 	// Set universal DPI
@@ -9,7 +9,10 @@ require (
 	github.com/Picocrypt/infectious v0.0.0-20250412183341-9f88c6307b39
 	github.com/Picocrypt/serpent v0.0.0-20240830233833-9ad6ab254fd7
 	github.com/Picocrypt/zxcvbn-go v0.0.0-20250412183938-d59695960527
+	github.com/hanwen/go-fuse/v2 v2.11.0
+	github.com/pkg/sftp v1.13.9
 	golang.org/x/crypto v0.40.0
+	golang.org/x/net v0.41.0
 )
 
 require (
@@ -17,5 +20,6 @@ require (
 	github.com/Picocrypt/glfw/v3.3/glfw v0.0.0-20250412234750-7b96bfdb8dd8 // indirect
 	github.com/Picocrypt/mainthread v0.0.0-20240831004314-496f638392b3 // indirect
 	github.com/Picocrypt/w32 v0.0.0-20240831001500-1183079d4d57 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/sys v0.34.0 // indirect
 )
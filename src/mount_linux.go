@@ -0,0 +1,112 @@
+//go:build linux
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// The root folder of a mounted volume, which lays out all of its files when it's mounted
+type volumeRoot struct {
+	fs.Inode
+	volume *openedVolume
+}
+
+func (r *volumeRoot) OnAdd(ctx context.Context) {
+	if r.volume.archive == nil {
+		entry := &volumeEntry{volume: r.volume}
+		r.AddChild(r.volume.name, r.NewPersistentInode(ctx, entry, fs.StableAttr{}), false)
+		return
+	}
+	for _, f := range r.volume.archive.File {
+		if strings.Contains(f.Name, "..") {
+			continue
+		}
+		dir := &r.Inode
+		parts := strings.Split(strings.Trim(f.Name, "/"), "/")
+		for i, part := range parts {
+			if i == len(parts)-1 && !f.FileInfo().IsDir() {
+				entry := &volumeEntry{volume: r.volume, file: f}
+				dir.AddChild(part, r.NewPersistentInode(ctx, entry, fs.StableAttr{}), false)
+				break
+			}
+			child := dir.GetChild(part)
+			if child == nil {
+				child = r.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
+				dir.AddChild(part, child, false)
+			}
+			dir = child
+		}
+	}
+}
+
+// A file in a mounted volume, or the volume's only file if it isn't a zip
+type volumeEntry struct {
+	fs.Inode
+	volume *openedVolume
+	file   *zip.File
+}
+
+func (e *volumeEntry) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0444
+	out.Size = uint64(e.volume.size)
+	if e.file != nil {
+		modified := e.file.Modified
+		out.Size = e.file.UncompressedSize64
+		out.SetTimes(nil, &modified, nil)
+	}
+	return 0
+}
+
+func (e *volumeEntry) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	return &entryHandle{newEntryReader(e.volume, e.file)}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// An open file in a mounted volume
+type entryHandle struct {
+	r *entryReader
+}
+
+func (h *entryHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.r.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *entryHandle) Release(ctx context.Context) syscall.Errno {
+	h.r.Close()
+	return 0
+}
+
+// Serve the files of 'volume' read-only at 'dir' until 'stop' is closed or it's unmounted
+func mountVolume(volume *openedVolume, dir string, stop <-chan struct{}) error {
+	server, err := fs.Mount(dir, &volumeRoot{volume: volume}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "picocrypt",
+			Name:        "picocrypt",
+			DirectMount: true, // Falls back to fusermount if Picocrypt can't mount by itself
+			Options:     []string{"ro"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stop
+		server.Unmount()
+	}()
+	server.Wait()
+	return nil
+}
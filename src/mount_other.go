@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// FUSE is only used on Linux
+func mountVolume(volume *openedVolume, dir string, stop <-chan struct{}) error {
+	return errors.New("mounting is only supported on Linux")
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQRCorrectBlock(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, 44)
+	for i := range data {
+		data[i] = byte(r.UintN(256))
+	}
+	block := append(bytes.Clone(data), qrEncodeBlock(data)...)
+	for errs := 0; errs <= qrECC; errs++ {
+		for range 20 {
+			damaged := bytes.Clone(block)
+			for _, i := range r.Perm(len(block))[:errs] {
+				damaged[i] ^= byte(1 + r.UintN(255))
+			}
+			ok := qrCorrectBlock(damaged)
+			if errs <= qrECC/2 && (!ok || !bytes.Equal(damaged, block)) {
+				t.Fatal("didn't correct", errs, "errors")
+			}
+			if errs > qrECC/2 && ok && bytes.Equal(damaged, block) {
+				t.Fatal("corrected", errs, "errors")
+			}
+		}
+	}
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for _, n := range []int{0, 1, 100, paperHeader + paperShare, qrData - 3} {
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(r.UintN(256))
+		}
+		code := qrEncode(payload)
+		if got, ok := qrDecode(code); !ok || !bytes.Equal(got, payload) {
+			t.Fatal("decode", n)
+		}
+
+		// Flipped modules are corrected, as long as there aren't too many
+		damaged := *code
+		for range 60 {
+			x, y := r.IntN(qrSize), r.IntN(qrSize)
+			damaged[y][x] = !damaged[y][x]
+		}
+		if got, ok := qrDecode(&damaged); !ok || !bytes.Equal(got, payload) {
+			t.Fatal("decode damaged", n)
+		}
+		for y := 9; y < qrSize-9; y++ {
+			for x := 9; x < qrSize-9; x++ {
+				damaged[y][x] = r.UintN(2) == 0
+			}
+		}
+		if _, ok := qrDecode(&damaged); ok {
+			t.Fatal("decoded noise", n)
+		}
+	}
+}
+
+// A scan of a page, seen through a projective transform from the scan to the page, with the contrast lowered
+type testScan struct {
+	page      *image.Gray
+	size      image.Point
+	transform [8]float64
+	noise     int // One pixel in this many is flipped, if not 0
+}
+
+func (s testScan) ColorModel() color.Model { return color.GrayModel }
+
+func (s testScan) Bounds() image.Rectangle { return image.Rectangle{Max: s.size} }
+
+func (s testScan) At(x int, y int) color.Color {
+	m := s.transform
+	fx, fy := float64(x)+0.5, float64(y)+0.5
+	w := m[6]*fx + m[7]*fy + 1
+	px, py := (m[0]*fx+m[1]*fy+m[2])/w, (m[3]*fx+m[4]*fy+m[5])/w
+	v := uint8(255)
+	if p := image.Pt(int(math.Floor(px)), int(math.Floor(py))); p.In(s.page.Bounds()) {
+		v = s.page.GrayAt(p.X, p.Y).Y
+	}
+	v = v/2 + 70
+	if s.noise != 0 && (x*7919+y*104729)%s.noise == 0 {
+		v = 255 - v
+	}
+	return color.Gray{v}
+}
+
+// Rotate the page by 'angle' and scale it, around its center
+func rotatedScan(page *image.Gray, angle float64, scale float64) testScan {
+	size := page.Bounds().Size()
+	d := math.Hypot(float64(size.X), float64(size.Y)) * scale
+	c, s := math.Cos(angle)/scale, math.Sin(angle)/scale
+	cx, cy := float64(size.X)/2, float64(size.Y)/2
+	return testScan{page, image.Pt(int(d), int(d)), [8]float64{c, -s, cx - c*d/2 + s*d/2, s, c, cy - s*d/2 - c*d/2, 0, 0}, 0}
+}
+
+// Photograph the page at an angle, so its top is 'tilt' narrower than its bottom
+func tiltedScan(page *image.Gray, tilt float64) testScan {
+	size := page.Bounds().Size()
+	g := tilt / float64(size.Y)
+	scaled := image.Pt(int(float64(size.X)/(1-tilt)), int(float64(size.Y)/(1-tilt)))
+	return testScan{page, scaled, [8]float64{1, 0, 0, 0, 1, 0, 0, g}, 0}
+}
+
+// Export a paper backup of 'data' as a PNG and read the page back
+func testPage(t *testing.T, dir string, data []byte) *image.Gray {
+	path := filepath.Join(dir, "data.bin")
+	os.WriteFile(path, data, 0600)
+	output := filepath.Join(dir, "paper.png")
+	sum, err := exportPaper(path, output)
+	if err != nil || sum != paperChecksum(data) {
+		t.Fatal(sum, err)
+	}
+	fin, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+	img, err := png.Decode(fin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.(*image.Gray)
+}
+
+// Save a scan as a PNG and import it
+func importScan(t *testing.T, dir string, scan image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scan); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "scan.png")
+	os.WriteFile(path, buf.Bytes(), 0600)
+	return importPaper([]string{path})
+}
+
+func TestPaperRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewPCG(5, 6))
+	data := make([]byte, 3000)
+	for i := range data {
+		data[i] = byte(r.UintN(256))
+	}
+	page := testPage(t, dir, data)
+
+	scans := map[string]testScan{
+		"straight":       {page, page.Bounds().Size(), [8]float64{1, 0, 0, 0, 1, 0, 0, 0}, 0},
+		"upside down":    rotatedScan(page, math.Pi, 1),
+		"sideways":       rotatedScan(page, math.Pi/2, 1.25),
+		"crooked":        rotatedScan(page, 0.2, 1.5),
+		"diagonal":       rotatedScan(page, -math.Pi/4, 2),
+		"noisy":          rotatedScan(page, 3, 1.5),
+		"tilted":         tiltedScan(page, 0.1),
+		"tilted further": tiltedScan(page, 0.25),
+	}
+	noisy := scans["noisy"]
+	noisy.noise = 40
+	scans["noisy"] = noisy
+	for name, scan := range scans {
+		got, err := importScan(t, dir, scan)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Error(name, err)
+		}
+	}
+
+	// A close-up photo of the first code of a keyfile, which is enough to restore it, taken at an angle
+	keyfile := data[:32]
+	page = testPage(t, dir, keyfile)
+	g := 0.2 / 800
+	closeUp := testScan{page, image.Pt(800, 800), [8]float64{0.5, 0, 60, 0, 0.5, 100, 0, g}, 0}
+	if got, err := importScan(t, dir, closeUp); err != nil || !bytes.Equal(got, keyfile) {
+		t.Error("close-up", err)
+	}
+}
+
+func TestPaperDamaged(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewPCG(7, 8))
+	data := make([]byte, 3000)
+	for i := range data {
+		data[i] = byte(r.UintN(256))
+	}
+	page := testPage(t, dir, data)
+	chunks := paperChunks(data)
+	spare := len(chunks) - (len(data)+paperShare-1)/paperShare
+
+	// Cover whole codes, as many as can be lost and then one more
+	cover := func(n int) *image.Gray {
+		damaged := image.NewGray(page.Bounds())
+		copy(damaged.Pix, page.Pix)
+		for i := range n {
+			x0, y0 := 113+i%5*300, 156+i/5*300
+			for y := y0; y < y0+qrSize*4; y++ {
+				for x := x0; x < x0+qrSize*4; x++ {
+					damaged.Pix[y*damaged.Stride+x] = 0xff
+				}
+			}
+		}
+		return damaged
+	}
+	if got, err := importScan(t, dir, cover(spare)); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("lost", spare, err)
+	}
+	if _, err := importScan(t, dir, cover(spare+1)); err == nil || err.Error() != "1 more QR codes are needed" {
+		t.Fatal("lost", spare+1, err)
+	}
+
+	// Scribbles across codes are corrected
+	damaged := cover(0)
+	for i := range 35 {
+		x0, y0 := 113+i%5*300, 156+i/5*300
+		for j := range qrSize * 4 {
+			for k := range 6 {
+				damaged.Pix[(y0+qrSize*2+k)*damaged.Stride+x0+j] = 0
+			}
+		}
+	}
+	if got, err := importScan(t, dir, damaged); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("scribbled", err)
+	}
+
+	// Codes from another backup are ignored
+	other := paperChunks(append(bytes.Clone(data[:len(data)-1]), data[len(data)-1]^1))
+	if got, err := paperJoin(append(append([][]byte{}, chunks...), other...)); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("another backup", err)
+	}
+
+	// A changed share is corrected if there are spare ones, and caught by the checksum otherwise
+	changed := append([][]byte{}, chunks...)
+	changed[0] = bytes.Clone(chunks[0])
+	changed[0][paperHeader+5] ^= 1
+	if _, err := paperJoin(changed[:len(changed)-spare]); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatal("changed share without spares", err)
+	}
+	if got, err := paperJoin(changed); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("changed share", err)
+	}
+	if _, err := importScan(t, dir, image.NewGray(image.Rect(0, 0, 100, 100))); err != errNoPaper {
+		t.Fatal("blank scan", err)
+	}
+}
+
+func TestPaperPDF(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	os.WriteFile(path, make([]byte, maxPaper), 0600)
+	if _, err := exportPaper(path, filepath.Join(dir, "paper.png")); err == nil {
+		t.Fatal("saved more than one page as a PNG")
+	}
+	if _, err := exportPaper(path, filepath.Join(dir, "paper.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	pdf, _ := os.ReadFile(filepath.Join(dir, "paper.pdf"))
+	pages := len(paperPages(make([]byte, maxPaper)))
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.Contains(pdf, []byte(fmt.Sprintf("/Count %d", pages))) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("not a PDF of", pages, "pages")
+	}
+	os.WriteFile(path, make([]byte, maxPaper+1), 0600)
+	if _, err := exportPaper(path, filepath.Join(dir, "paper.pdf")); err == nil {
+		t.Fatal("exported more than", maxPaper)
+	}
+}
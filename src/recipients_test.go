@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The X25519 points of small order, and those equal to them modulo p, which give an all-zero shared secret
+var lowOrderPoints = []string{
+	"0000000000000000000000000000000000000000000000000000000000000000",
+	"0100000000000000000000000000000000000000000000000000000000000000",
+	"e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b800",
+	"5f9c95bca3508c24b1d0b1559c83ef5b04445cc4581c8e86d8224eddd09f1157",
+	"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+	"edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+	"eeffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+}
+
+// Create an identity in 'dir' and read both halves of it back
+func testIdentity(t *testing.T, dir string, name string) (identity []byte, recipient []byte) {
+	path := filepath.Join(dir, name+".key")
+	if err := createIdentity(path, name); err != nil {
+		t.Fatal(err)
+	}
+	private, err := readIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := readRecipient(filepath.Join(dir, name+".pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private.Bytes, public.Bytes
+}
+
+func TestWrapKey(t *testing.T) {
+	dir := t.TempDir()
+	alice, aliceRecipient := testIdentity(t, dir, "alice")
+	bob, _ := testIdentity(t, dir, "bob")
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	stanza := wrapKey(key, aliceRecipient)
+	if len(stanza) != stanzaSize {
+		t.Fatal("stanza size", len(stanza))
+	}
+	if got := unwrapKey(stanza, alice); !bytes.Equal(got, key) {
+		t.Fatal("the identity can't unwrap the key")
+	}
+	if got := unwrapKey(stanza, bob); got != nil && bytes.Equal(got, key) {
+		t.Fatal("another identity unwrapped the key")
+	}
+
+	// Wrapping the same key again looks unrelated
+	if other := wrapKey(key, aliceRecipient); bytes.Equal(other[:32], stanza[:32]) || bytes.Equal(other[stanzaSize-32:], stanza[stanzaSize-32:]) {
+		t.Fatal("stanzas repeat")
+	}
+
+	// Changing any part of the stanza gives a different key, which the key hash then rejects
+	for _, i := range []int{0, 31, 32, stanzaSize - 33, stanzaSize - 32, stanzaSize - 1} {
+		changed := bytes.Clone(stanza)
+		changed[i] ^= 1
+		if got := unwrapKey(changed, alice); got != nil && bytes.Equal(got, key) {
+			t.Fatal("changed byte", i)
+		}
+	}
+
+	// An ephemeral key of small order is refused rather than giving a predictable secret
+	for _, point := range lowOrderPoints {
+		changed := bytes.Clone(stanza)
+		hex.Decode(changed[:32], []byte(point))
+		if got := unwrapKey(changed, alice); got != nil {
+			t.Fatal("unwrapped with low-order point", point)
+		}
+	}
+}
+
+func TestReadRecipientLowOrder(t *testing.T) {
+	dir := t.TempDir()
+	_, recipient := testIdentity(t, dir, "alice")
+	path := filepath.Join(dir, "bad.pub")
+	for _, point := range lowOrderPoints {
+		public := bytes.Clone(recipient)
+		hex.Decode(public[:32], []byte(point))
+		os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PICOCRYPT RECIPIENT", Bytes: public}), 0644)
+		if _, err := readRecipient(path); err == nil {
+			t.Fatal("read recipient with low-order point", point)
+		}
+	}
+
+	// So are ML-KEM keys that aren't reduced
+	public := bytes.Clone(recipient)
+	for i := 32; i < 32+384; i++ {
+		public[i] = 0xff
+	}
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PICOCRYPT RECIPIENT", Bytes: public}), 0644)
+	if _, err := readRecipient(path); err == nil {
+		t.Fatal("read recipient with an invalid ML-KEM key")
+	}
+}
+
+func TestRecipientVolume(t *testing.T) {
+	dir := t.TempDir()
+	testIdentity(t, dir, "alice")
+	testIdentity(t, dir, "bob")
+	data := make([]byte, 3*MiB+17)
+	rand.Read(data)
+	in := filepath.Join(dir, "data.bin")
+	os.WriteFile(in, data, 0600)
+	keyfile := filepath.Join(dir, "keyfile")
+	os.WriteFile(keyfile, []byte("keyfile"), 0600)
+
+	if code := cli([]string{"encrypt", "-r", filepath.Join(dir, "alice.pub"), "-k", keyfile, in}); code != 0 {
+		t.Fatal("encrypt", code, mainStatus)
+	}
+	os.Remove(in)
+	if code := cli([]string{"decrypt", "-i", filepath.Join(dir, "bob.key"), "-k", keyfile, in + ".pcv"}); code == 0 || mainOutcome != outcomeWrongPassword {
+		t.Fatal("decrypted with the wrong identity", code, mainStatus)
+	}
+	if _, err := os.Stat(in); err == nil {
+		t.Fatal("output left behind")
+	}
+	if code := cli([]string{"decrypt", "-i", filepath.Join(dir, "alice.key"), "-k", keyfile, in + ".pcv"}); code != 0 {
+		t.Fatal("decrypt", code, mainStatus)
+	}
+	if got, _ := os.ReadFile(in); !bytes.Equal(got, data) {
+		t.Fatal("decrypted data doesn't match")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInv(byte(a))) != 1 {
+			t.Fatal("inverse of", a)
+		}
+		if gfMul(byte(a), 1) != byte(a) || gfMul(byte(a), 0) != 0 {
+			t.Fatal("identity of", a)
+		}
+	}
+	// 0x53 and 0xca are inverses in the AES field
+	if gfMul(0x53, 0xca) != 1 || gfMul(0x57, 0x83) != 0xc1 {
+		t.Fatal("AES field")
+	}
+}
+
+// Every set of at least 'needed' shares recovers the secret, in any order, and every smaller set doesn't
+func TestSplitSecret(t *testing.T) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	for total := 2; total <= 8; total++ {
+		for needed := 2; needed <= total; needed++ {
+			shares := splitSecret(secret, needed, total)
+			for mask := 1; mask < 1<<total; mask++ {
+				var set [][]byte
+				for i := range total {
+					if mask>>i&1 == 1 {
+						set = append(set, shares[i])
+					}
+				}
+				got, err := combineShares(set)
+				if bits.OnesCount(uint(mask)) < needed {
+					if err == nil {
+						t.Fatalf("%d of %d: combined %b", needed, total, mask)
+					}
+					continue
+				}
+				if err != nil || !bytes.Equal(got, secret) {
+					t.Fatalf("%d of %d: %b: %v", needed, total, mask, err)
+				}
+				for i, j := 0, len(set)-1; i < j; i, j = i+1, j-1 {
+					set[i], set[j] = set[j], set[i]
+				}
+				if got, err := combineShares(set); err != nil || !bytes.Equal(got, secret) {
+					t.Fatalf("%d of %d: %b reversed: %v", needed, total, mask, err)
+				}
+			}
+		}
+	}
+
+	// The most shares the GUI allows
+	shares := splitSecret(secret, 16, 16)
+	if got, err := combineShares(shares); err != nil || !bytes.Equal(got, secret) {
+		t.Fatal("16 of 16", err)
+	}
+	if _, err := combineShares(shares[1:]); err == nil {
+		t.Fatal("15 of 16 combined")
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	shares := splitSecret(secret, 3, 5)
+	cases := map[string][][]byte{
+		"none":      nil,
+		"duplicate": {shares[0], shares[0], shares[1]},
+		"x = 0":     {shares[0], shares[1], append([]byte{3, 0}, shares[2][2:]...)},
+		"short":     {shares[0], shares[1], shares[2][:20]},
+		"needs one": {append([]byte{1}, shares[0][1:]...), shares[1]},
+	}
+	for name, set := range cases {
+		if _, err := combineShares(set); err == nil {
+			t.Error(name)
+		}
+	}
+
+	// A changed share gives a different secret rather than an error, which the key hash then catches
+	changed := bytes.Clone(shares[2])
+	changed[10] ^= 1
+	if got, err := combineShares([][]byte{shares[0], shares[1], changed}); err != nil || bytes.Equal(got, secret) {
+		t.Fatal("changed share", err)
+	}
+}
+
+func TestReadKeyfilesShares(t *testing.T) {
+	dir := t.TempDir()
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	var paths []string
+	for i, share := range splitSecret(secret, 2, 3) {
+		path := filepath.Join(dir, "volume.share"+string(rune('1'+i)))
+		if err := writeShare(path, share, 3); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	for _, set := range [][]string{paths[:2], paths[1:], {paths[2], paths[0]}, paths} {
+		if got, err := readKeyfiles(set, false); err != nil || !bytes.Equal(got, secret) {
+			t.Fatal(set, err)
+		}
+	}
+	if _, err := readKeyfiles(paths[:1], false); err == nil {
+		t.Fatal("1 of 2 shares combined")
+	}
+
+	// Files that aren't shares make every file a keyfile, even the shares
+	keyfile := filepath.Join(dir, "keyfile")
+	os.WriteFile(keyfile, []byte("not a share"), 0600)
+	large := filepath.Join(dir, "large")
+	data, _ := os.ReadFile(paths[0])
+	os.WriteFile(large, append(data, make([]byte, 4*KiB)...), 0600)
+	wrongSize := filepath.Join(dir, "wrong")
+	block, _ := readKey(paths[0], "PICOCRYPT SHARE", 34)
+	block.Bytes = block.Bytes[:33]
+	os.WriteFile(wrongSize, pem.EncodeToMemory(block), 0600)
+	for _, set := range [][]string{{keyfile}, {paths[0], keyfile}, {paths[0], large}, {paths[0], wrongSize}} {
+		got, err := readKeyfiles(set, true)
+		if err != nil || !bytes.Equal(got, hashKeyfiles(set, true)) || bytes.Equal(got, secret) {
+			t.Fatal(set, err)
+		}
+	}
+}
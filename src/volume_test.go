@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Encrypt 'data' into a volume in 'dir', with the options that 'setup' sets
+func testVolume(t *testing.T, dir string, data []byte, setup func()) string {
+	in := filepath.Join(dir, "data.bin")
+	os.WriteFile(in, data, 0600)
+	os.Remove(in + ".pcv")
+	resetUI()
+	onDrop([]string{in})
+	for scanning {
+		time.Sleep(10 * time.Millisecond)
+	}
+	password, cpassword = "password", "password"
+	setup()
+	work()
+	working = false
+	if mainStatus != "Completed" {
+		t.Fatal(mainStatus)
+	}
+	os.Remove(in)
+	return in + ".pcv"
+}
+
+// Open a volume for mounting, making sure it's read in place
+func testOpen(t *testing.T, volume string) (*openedVolume, error) {
+	v, err := openVolume(volume, func() { password = "password" })
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.file.(*volumeReader); !ok {
+		t.Fatal("decrypted up front")
+	}
+	return v, nil
+}
+
+func TestVolumeReader(t *testing.T) {
+	dir := t.TempDir()
+	r := mathrand.New(mathrand.NewPCG(9, 10))
+	cases := []struct {
+		name  string
+		size  int
+		setup func()
+	}{
+		{"empty", 0, func() {}},
+		{"whole blocks", 2 * MiB, func() {}},
+		{"paranoid", 3*MiB + 1000, func() { paranoid = true }},
+		{"reedsolo", 3*MiB + 1000, func() { reedsolo = true }},
+		{"padding", 1000, func() { padding, padSelected = true, 3 }},
+		{"all", 3*MiB + 1000, func() { paranoid, reedsolo, padding, padSelected = true, true, true, 3 }},
+	}
+	for _, c := range cases {
+		name, size := c.name, c.size
+		data := make([]byte, size)
+		rand.Read(data)
+		v, err := testOpen(t, testVolume(t, dir, data, c.setup))
+		if err != nil {
+			t.Fatal(name, size, err)
+		}
+		if v.size != int64(size) {
+			t.Fatal(name, size, "opened with size", v.size)
+		}
+		got := make([]byte, size)
+		if _, err := v.file.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+			t.Fatal(name, size, err)
+		}
+
+		// Reads from anywhere, across blocks and past the end
+		for range 20 {
+			off := r.IntN(size + 1)
+			got := make([]byte, r.IntN(MiB+MiB/2))
+			n, err := v.file.ReadAt(got, int64(off))
+			if n != min(len(got), size-off) || (n < len(got) && err != io.EOF) || !bytes.Equal(got[:n], data[off:off+n]) {
+				t.Fatal(name, size, off, len(got), n, err)
+			}
+		}
+		v.file.Close()
+	}
+
+	// A split volume is read from its chunks, without recombining them
+	data := make([]byte, 3*MiB+1000)
+	rand.Read(data)
+	volume := testVolume(t, dir, data, func() { split, splitSize, splitSelected = true, "1", 1 })
+	v, err := testOpen(t, volume+".0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(data))
+	if _, err := v.file.ReadAt(got, 0); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("split", err)
+	}
+	v.file.Close()
+	if _, err := os.Stat(volume); err == nil {
+		t.Fatal("recombined")
+	}
+}
+
+func TestVolumeReaderModified(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 3*MiB+1000)
+	rand.Read(data)
+	stride := int64(MiB + 16)
+	modify := func(volume string, change func(f *os.File)) {
+		f, err := os.OpenFile(volume, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change(f)
+		f.Close()
+	}
+
+	// A changed block can't be read, while the others still can
+	volume := testVolume(t, dir, data, func() {})
+	modify(volume, func(f *os.File) { f.WriteAt([]byte{0}, 789+stride+100) })
+	v, err := testOpen(t, volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 1000)
+	if _, err := v.file.ReadAt(got, int64(MiB)); err == nil {
+		t.Fatal("read a changed block")
+	}
+	if _, err := v.file.ReadAt(got, 2*int64(MiB)); err != nil || !bytes.Equal(got, data[2*MiB:2*MiB+1000]) {
+		t.Fatal(err)
+	}
+	v.file.Close()
+
+	// Reed-Solomon repairs it instead
+	volume = testVolume(t, dir, data, func() { reedsolo = true })
+	modify(volume, func(f *os.File) { f.WriteAt([]byte{0, 0}, 789+int64(MiB/128*136+48)+100) })
+	if v, err = testOpen(t, volume); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.file.ReadAt(got, int64(MiB)); err != nil || !bytes.Equal(got, data[MiB:MiB+1000]) {
+		t.Fatal(err)
+	}
+	v.file.Close()
+
+	// Blocks can't be swapped
+	volume = testVolume(t, dir, data, func() {})
+	modify(volume, func(f *os.File) {
+		first, second := make([]byte, stride), make([]byte, stride)
+		f.ReadAt(first, 789)
+		f.ReadAt(second, 789+stride)
+		f.WriteAt(second, 789)
+		f.WriteAt(first, 789+stride)
+	})
+	if v, err = testOpen(t, volume); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.file.ReadAt(got, 0); err == nil {
+		t.Fatal("read a moved block")
+	}
+	v.file.Close()
+
+	// Or cut off at the end
+	volume = testVolume(t, dir, data, func() {})
+	modify(volume, func(f *os.File) { f.Truncate(789 + 3*stride) })
+	if _, err := testOpen(t, volume); err == nil {
+		t.Fatal("opened a truncated volume")
+	}
+
+	// And the padding can't be turned off to show it
+	volume = testVolume(t, dir, data, func() { padding, padSelected = true, 3 })
+	modify(volume, func(f *os.File) { f.WriteAt(rsEncode(rs5, []byte{0, 0, 0, 2, 0}), 30) })
+	if _, err := testOpen(t, volume); err == nil {
+		t.Fatal("opened without the padding flag")
+	}
+}
+
+// A volume too large to write out, which is all zeros except for one block
+type sparseVolume struct {
+	off   int64
+	block []byte
+}
+
+func (s *sparseVolume) ReadAt(data []byte, off int64) (int, error) {
+	clear(data)
+	for i := range data {
+		if j := off + int64(i) - s.off; j >= 0 && j < int64(len(s.block)) {
+			data[i] = s.block[j]
+		}
+	}
+	return len(data), nil
+}
+
+func (s *sparseVolume) Read(data []byte) (int, error)                { return 0, io.EOF }
+func (s *sparseVolume) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (s *sparseVolume) Close() error                                 { return nil }
+
+// Blocks after the first 60 GiB use the next nonce and IV, with the ciphers started partway in
+func TestVolumeReaderRekey(t *testing.T) {
+	key, nonce, serpentIV, hkdfSalt := make([]byte, 32), make([]byte, 24), make([]byte, 16), make([]byte, 32)
+	rand.Read(key)
+	rand.Read(nonce)
+	rand.Read(serpentIV)
+	rand.Read(hkdfSalt)
+
+	// Encrypt the second block after the nonce changes, the way encryptVolume does
+	c := newVolumeCipher(key, nonce, serpentIV, hkdfSalt, true, true, false)
+	index := int64(60*GiB/MiB + 1)
+	for range index {
+		c.advance()
+	}
+	skip := make([]byte, MiB)
+	c.serpent.XORKeyStream(skip, skip)
+	c.chacha.XORKeyStream(skip, skip)
+	data := make([]byte, MiB)
+	rand.Read(data)
+	block := make([]byte, MiB)
+	c.serpent.XORKeyStream(block, data)
+	c.chacha.XORKeyStream(block, block)
+	block = append(block, c.blockMAC(index, true, block)...)
+
+	stride := int64(MiB + 16)
+	file := &sparseVolume{off: index * stride, block: block}
+	c = newVolumeCipher(key, nonce, serpentIV, hkdfSalt, true, true, false)
+	v, err := newVolumeReader(file, 0, (index+1)*stride, c, nil, nonce, serpentIV, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, MiB)
+	if _, err := v.ReadAt(got, index*int64(MiB)); err != nil || !bytes.Equal(got, data) {
+		t.Fatal("wrong data", err)
+	}
+}
+
+// Volumes holding files with the same name are served in folders of their own, each opened when it's first used
+func TestServeFolders(t *testing.T) {
+	dir := t.TempDir()
+	var volumes []*servedVolume
+	var contents [][]byte
+	opens := make(map[string]int)
+	for _, name := range []string{"first", "second", "wrong"} {
+		os.Mkdir(filepath.Join(dir, name), 0700)
+		data := make([]byte, 5000)
+		rand.Read(data)
+		contents = append(contents, data)
+		volume := testVolume(t, filepath.Join(dir, name), data, func() {})
+		volumes = append(volumes, &servedVolume{name: name + ".pcv", open: func() (*openedVolume, error) {
+			opens[name]++
+			return openVolume(volume, func() {
+				password = "password"
+				if name == "wrong" {
+					password = "wrong"
+				}
+			})
+		}})
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- serveVolumes(volumes, listener, "token", stop) }()
+	get := func(path string) (int, []byte) {
+		res, err := http.Get("http://" + listener.Addr().String() + "/token/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		return res.StatusCode, data
+	}
+
+	if code, data := get(""); code != 200 || !strings.Contains(string(data), `href="first.pcv/"`) || len(opens) != 0 {
+		t.Fatal("listing", code, opens)
+	}
+	for i, name := range []string{"first", "second"} {
+		for range 2 {
+			if code, data := get(name + ".pcv/data.bin"); code != 200 || !bytes.Equal(data, contents[i]) {
+				t.Fatal(name, code)
+			}
+		}
+		if opens[name] != 1 {
+			t.Fatal(name, "opened", opens[name], "times")
+		}
+	}
+	if code, _ := get("wrong.pcv/data.bin"); code == 200 {
+		t.Fatal("served with a wrong password")
+	}
+	if code, _ := get("wrong.pcv/"); code == 200 || opens["wrong"] != 1 {
+		t.Fatal("tried again", code, opens["wrong"])
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Volumes with flags from a newer version are refused, rather than decrypted as if the flags weren't there
+func TestKnownFlags(t *testing.T) {
+	for _, flags := range [][]byte{{0, 0, 0, 0, 0}, {63, 2, 1, 3, 1}, {1, 1, 0, 1, 0}} {
+		if !knownFlags(flags) {
+			t.Fatal("refused", flags)
+		}
+	}
+	for _, flags := range [][]byte{{64, 0, 0, 2, 0}, {0, 3, 0, 2, 0}, {0, 0, 2, 2, 0}, {0, 0, 0, 6, 0}, {0, 0, 0, 2, 2}} {
+		if knownFlags(flags) {
+			t.Fatal("accepted", flags)
+		}
+	}
+
+	volume := testVolume(t, t.TempDir(), []byte("data"), func() {})
+	f, err := os.OpenFile(volume, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt(rsEncode(rs5, []byte{0, 0, 0, 6, 0}), 30)
+	f.Close()
+	onDrop([]string{volume})
+	if mainStatus != "The volume needs a newer version of Picocrypt" {
+		t.Fatal(mainStatus)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Wakes the watch loop when something changes in a watched folder, using inotify
+type folderWatcher struct {
+	fd      int
+	file    *os.File // Reads through Go's poller, so closing it stops the reading goroutine
+	watched map[string]bool
+	events  chan struct{}
+}
+
+func newFolderWatcher() (*folderWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &folderWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watched: make(map[string]bool),
+		events:  make(chan struct{}, 1),
+	}
+	go func() {
+		buf := make([]byte, 64*KiB)
+		for {
+			// Only whether something happened matters, as the loop looks at the files itself
+			if _, err := w.file.Read(buf); err != nil {
+				close(w.events)
+				return
+			}
+			select {
+			case w.events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Watch the folder 'dir' for new and changed files, if it isn't already
+func (w *folderWatcher) add(dir string) error {
+	if w.watched[dir] {
+		return nil
+	}
+	mask := uint32(syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_ATTRIB)
+	if _, err := syscall.InotifyAddWatch(w.fd, dir, mask); err != nil {
+		return err
+	}
+	w.watched[dir] = true
+	return nil
+}
+
+func (w *folderWatcher) Close() error {
+	return w.file.Close()
+}
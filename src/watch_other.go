@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Without inotify, the watch loop checks the folders every second instead
+type folderWatcher struct {
+	events chan struct{}
+}
+
+func newFolderWatcher() (*folderWatcher, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+func (w *folderWatcher) add(dir string) error {
+	return nil
+}
+
+func (w *folderWatcher) Close() error {
+	return nil
+}